@@ -2,8 +2,15 @@ package cli
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/LajnaLegenden/transpiler4/helpers"
 	"github.com/urfave/cli/v2"
@@ -15,7 +22,7 @@ func BuildCommand() *cli.Command {
 		Name:    "build",
 		Aliases: []string{"b"},
 		Usage:   "Build and copy this project once",
-		Flags: []cli.Flag{
+		Flags: append([]cli.Flag{
 			&cli.StringFlag{
 				Name:    "path",
 				Aliases: []string{"p"},
@@ -26,14 +33,32 @@ func BuildCommand() *cli.Command {
 				Aliases: []string{"o"},
 				Usage:   "Change the output folder",
 			},
-		},
+			jobsFlag(),
+			&cli.BoolFlag{
+				Name:  "fail-fast",
+				Usage: "Cancel the remaining builds when a package fails",
+			},
+			&cli.BoolFlag{
+				Name:  "json",
+				Usage: "Print the build results as JSON",
+			},
+			&cli.BoolFlag{
+				Name:  "no-cache",
+				Usage: "Run the build commands even if the sources did not change",
+			},
+		}, packageSelectionFlags()...),
 		Action: BuildAction,
 	}
 }
 
 // BuildAction handles the build command execution
 func BuildAction(c *cli.Context) error {
-	fmt.Println("We are building and copying this project once")
+	// Keep stdout clean for the JSON output
+	status := io.Writer(os.Stdout)
+	if c.Bool("json") {
+		status = os.Stderr
+	}
+	fmt.Fprintln(status, "We are building and copying this project once")
 	projectPath, err := helpers.GetProjectPath(c.String("path"))
 	var webappPath string
 	if c.Bool("out") {
@@ -52,26 +77,183 @@ func BuildAction(c *cli.Context) error {
 	if err != nil {
 		return err
 	}
-	fmt.Printf("Using project path: %s\n", projectPath)
+	fmt.Fprintf(status, "Using project path: %s\n", projectPath)
+	helpers.SetBuildCacheEnabled(!c.Bool("no-cache"))
 	packages, err := helpers.FindNodePackages(projectPath)
 	if err != nil {
 		return err
 	}
 	buildablePackages := helpers.GetBuildablePackages(packages)
-	selectedPackages := helpers.SelectPackages(buildablePackages)
-	var wg sync.WaitGroup
-	for _, pkg := range selectedPackages {
-		wg.Add(1)
-		go func(pkg helpers.NodePackage) {
-			defer wg.Done()
-			ctx, cancel := context.WithCancel(context.Background())
-			defer cancel()
-			err := helpers.BuildPackage(ctx, pkg, webappPath)
-			if err != nil {
-				fmt.Printf("Error building package: %s\n", err)
+	selectedPackages, err := selectPackages(c, buildablePackages)
+	if err != nil {
+		return err
+	}
+	var (
+		resultsMux sync.Mutex
+		results    = make(map[string]*helpers.BuildResult)
+		failedFast string // The package whose failure cancelled the other builds
+	)
+	graph := helpers.NewDependencyGraph(buildablePackages).Subgraph(selectedPackages)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// Dependencies are built before the packages that depend on them
+	scheduler := helpers.NewScheduler(c.Int("jobs"), log.Default())
+	failures, err := graph.Run(ctx, func(ctx context.Context, pkg helpers.NodePackage) error {
+		var (
+			result *helpers.BuildResult
+			err    error
+		)
+		done, _ := scheduler.Submit(pkg.PackageJson.Name, func() {
+			result, err = helpers.BuildPackage(ctx, pkg, webappPath)
+		})
+		<-done
+		resultsMux.Lock()
+		results[pkg.PackageJson.Name] = result
+		if err != nil && c.Bool("fail-fast") && !result.Cancelled && failedFast == "" {
+			failedFast = pkg.PackageJson.Name
+			fmt.Fprintf(status, "Build of %s failed, cancelling remaining builds\n", pkg.PackageJson.Name)
+			cancel()
+		}
+		resultsMux.Unlock()
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	// Packages skipped because a dependency failed never got a result
+	buildOrder, err := graph.Order()
+	if err != nil {
+		return err
+	}
+	ordered := make([]*helpers.BuildResult, 0, len(buildOrder))
+	for _, pkg := range buildOrder {
+		result, ok := results[pkg.PackageJson.Name]
+		if !ok {
+			result = helpers.NewBuildResult(pkg)
+			result.Skipped = true
+			if failure := failures[pkg.PackageJson.Name]; failure != nil {
+				result.Error = failure.Error()
+				if failedFast != "" && errors.Is(failure, context.Canceled) {
+					result.Error = fmt.Sprintf("skipped because %s failed and --fail-fast cancelled the build", failedFast)
+				}
+			}
+		} else if result.Cancelled && failedFast != "" {
+			// Only the package that failed first is reported as failed
+			result.Error = fmt.Sprintf("cancelled because %s failed and --fail-fast cancelled the build", failedFast)
+		}
+		ordered = append(ordered, result)
+	}
+
+	if c.Bool("json") {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.SetEscapeHTML(false)
+		if err := encoder.Encode(ordered); err != nil {
+			return err
+		}
+	} else {
+		printBuildSummary(ordered)
+	}
+	return newBuildFailedError(ordered)
+}
+
+// buildFailedError lists every package that failed to build, so mtcli build
+// exits non-zero. Packages that were cancelled or skipped are only counted.
+type buildFailedError struct {
+	total    int
+	failures []*helpers.BuildResult
+	notBuilt int
+}
+
+// newBuildFailedError returns an error for the unsuccessful results, or nil if every build succeeded
+func newBuildFailedError(results []*helpers.BuildResult) error {
+	buildErr := &buildFailedError{total: len(results)}
+	for _, result := range results {
+		switch {
+		case result.Skipped || result.Cancelled:
+			buildErr.notBuilt++
+		case !result.Success:
+			buildErr.failures = append(buildErr.failures, result)
+		}
+	}
+	if len(buildErr.failures) == 0 && buildErr.notBuilt == 0 {
+		return nil
+	}
+	return buildErr
+}
+
+func (e *buildFailedError) Error() string {
+	var message strings.Builder
+	if len(e.failures) == 0 {
+		fmt.Fprintf(&message, "%d of %d packages were cancelled or skipped", e.notBuilt, e.total)
+		return message.String()
+	}
+	fmt.Fprintf(&message, "%d of %d packages failed to build", len(e.failures), e.total)
+	if e.notBuilt > 0 {
+		fmt.Fprintf(&message, ", %d more were cancelled or skipped", e.notBuilt)
+	}
+	message.WriteString(":")
+	for _, result := range e.failures {
+		fmt.Fprintf(&message, "\n  %s: %s", result.Package, describeFailure(result))
+	}
+	return message.String()
+}
+
+// printBuildSummary prints a table with one row per built package
+func printBuildSummary(results []*helpers.BuildResult) {
+	fmt.Println()
+	fmt.Printf("%-40s %-9s %-10s %s\n", "Package Name", "Result", "Duration", "Details")
+	fmt.Println(strings.Repeat("-", 90))
+	for _, result := range results {
+		status := "ok"
+		details := summarizeArtifacts(result.Artifacts)
+		switch {
+		case result.Skipped:
+			status = "skipped"
+			details = result.Error
+		case result.Cancelled:
+			status = "cancelled"
+			details = result.Error
+		case !result.Success:
+			status = "failed"
+			details = describeFailure(result)
+		case result.Cached:
+			status = "cached"
+		}
+		fmt.Printf("%-40s %-9s %-10s %s\n",
+			result.Package,
+			status,
+			result.Duration().Round(time.Millisecond),
+			details)
+	}
+}
+
+// describeFailure explains which step of a build failed and why
+func describeFailure(result *helpers.BuildResult) string {
+	if result.Cancelled {
+		return "cancelled"
+	}
+	if len(result.Steps) > 0 {
+		last := result.Steps[len(result.Steps)-1]
+		if last.Command == result.FailedStep && last.ExitCode > 0 {
+			details := fmt.Sprintf("%q exited with %d", last.Command, last.ExitCode)
+			if len(last.StderrTail) > 0 {
+				details += ": " + last.StderrTail[len(last.StderrTail)-1]
 			}
-		}(pkg)
+			return details
+		}
+	}
+	return result.Error
+}
+
+// summarizeArtifacts adds up the sync stats of all copied outputs
+func summarizeArtifacts(artifacts []helpers.SyncStats) string {
+	var total helpers.SyncStats
+	for _, stats := range artifacts {
+		total.Added += stats.Added
+		total.Changed += stats.Changed
+		total.Removed += stats.Removed
 	}
-	wg.Wait()
-	return nil
+	return total.String()
 }
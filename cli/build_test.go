@@ -0,0 +1,39 @@
+package cli
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/LajnaLegenden/transpiler4/helpers"
+)
+
+func TestBuildFailedError(t *testing.T) {
+	results := []*helpers.BuildResult{
+		{Package: "ok", Success: true},
+		{Package: "broken", Error: "exit status 2"},
+		{Package: "cancelled", Cancelled: true, Error: "context canceled"},
+		{Package: "skipped", Skipped: true, Error: "skipped because the build was cancelled"},
+	}
+
+	err := newBuildFailedError(results)
+	if err == nil {
+		t.Fatal("Expected an error when a package failed")
+	}
+	message := err.Error()
+	if !strings.HasPrefix(message, "1 of 4 packages failed to build, 2 more were cancelled or skipped:") {
+		t.Errorf("Expected only broken to be counted as failed, got: %s", message)
+	}
+	if !strings.Contains(message, "broken: exit status 2") || strings.Contains(message, "\n  cancelled:") || strings.Contains(message, "\n  skipped:") {
+		t.Errorf("Expected only broken to be listed, got: %s", message)
+	}
+
+	// Cancelled builds still make mtcli build exit non-zero
+	err = newBuildFailedError(results[2:])
+	if err == nil || err.Error() != "2 of 2 packages were cancelled or skipped" {
+		t.Errorf("Expected the cancelled and skipped packages to be counted, got: %v", err)
+	}
+
+	if err := newBuildFailedError(results[:1]); err != nil {
+		t.Errorf("Expected no error when every build succeeded, got: %v", err)
+	}
+}
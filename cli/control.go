@@ -0,0 +1,160 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/LajnaLegenden/transpiler4/helpers"
+	"github.com/LajnaLegenden/transpiler4/logsocket"
+)
+
+// watchAddCommand returns the command adding packages to a running watch session
+func watchAddCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "add",
+		Usage:     "Start watching more packages in the running watch session",
+		ArgsUsage: "[package name or glob...]",
+		Flags:     []cli.Flag{sessionPathFlag()},
+		Action:    WatchAddAction,
+	}
+}
+
+// watchRemoveCommand returns the command removing packages from a running watch session
+func watchRemoveCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "remove",
+		Aliases:   []string{"rm"},
+		Usage:     "Stop watching packages in the running watch session",
+		ArgsUsage: "<package name or glob...>",
+		Flags:     []cli.Flag{sessionPathFlag()},
+		Action:    WatchRemoveAction,
+	}
+}
+
+// sessionPathFlag returns the flag naming the project folder of the running watch session
+func sessionPathFlag() cli.Flag {
+	return &cli.StringFlag{
+		Name:    "path",
+		Aliases: []string{"p"},
+		Usage:   "Path to the project folder the watch session runs for",
+	}
+}
+
+// WatchAddAction adds the named packages to the running watch session, or
+// the packages picked with the fuzzy finder when none are named
+func WatchAddAction(c *cli.Context) error {
+	patterns := c.Args().Slice()
+	if len(patterns) == 0 {
+		var available []helpers.NodePackage
+		if err := sessionRequest(c, http.MethodGet, "/api/available", nil, &available); err != nil {
+			return err
+		}
+		if len(available) == 0 {
+			return errors.New("every package is already watched")
+		}
+		selected, err := helpers.SelectPackages(available)
+		if err != nil {
+			return err
+		}
+		for _, pkg := range selected {
+			patterns = append(patterns, pkg.PackageJson.Name)
+		}
+	}
+
+	for _, pattern := range patterns {
+		var added []logsocket.PackageState
+		if err := sessionRequest(c, http.MethodPost, "/api/packages", map[string]string{"package": pattern}, &added); err != nil {
+			return err
+		}
+		for _, state := range added {
+			fmt.Printf("Watching %s\n", state.Name)
+		}
+	}
+	return nil
+}
+
+// WatchRemoveAction removes the named packages from the running watch session
+func WatchRemoveAction(c *cli.Context) error {
+	patterns := c.Args().Slice()
+	if len(patterns) == 0 {
+		return errors.New("name the packages to stop watching, like 'mtcli watch remove @mediatool/ui'")
+	}
+
+	for _, pattern := range patterns {
+		var removed []logsocket.PackageState
+		if err := sessionRequest(c, http.MethodDelete, "/api/packages/"+escapePackagePath(pattern), nil, &removed); err != nil {
+			return err
+		}
+		for _, state := range removed {
+			fmt.Printf("Stopped watching %s\n", state.Name)
+		}
+	}
+	return nil
+}
+
+// sessionRequest calls the control API of the running watch session and decodes the response into result
+func sessionRequest(c *cli.Context, method string, path string, body any, result any) error {
+	return requestSession(runningSessionURL(c), method, path, body, result)
+}
+
+// runningSessionURL returns the URL of the log server of the session running
+// for the project folder, found through its session lock. Falls back to the
+// default port when there is no lock to read.
+func runningSessionURL(c *cli.Context) string {
+	projectPath, err := helpers.GetProjectPath(c.String("path"))
+	if err == nil {
+		if running, err := helpers.ReadSessionLock(projectPath); err == nil && running != nil && running.Port != 0 {
+			return sessionURL(running)
+		}
+	}
+	return logsocket.ServerURL(logsocket.DefaultBind, logsocket.DefaultPort)
+}
+
+// requestSession calls the control API of the watch session with its log server at address
+func requestSession(address string, method string, path string, body any, result any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest(method, address+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	response, err := client.Do(request)
+	if err != nil {
+		return fmt.Errorf("no watch session found at %s, start one with 'mtcli watch': %w", address, err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		var failure struct {
+			Error string `json:"error"`
+		}
+		if err := json.NewDecoder(response.Body).Decode(&failure); err != nil || failure.Error == "" {
+			return fmt.Errorf("the watch session responded with %s", response.Status)
+		}
+		return errors.New(failure.Error)
+	}
+	return json.NewDecoder(response.Body).Decode(result)
+}
+
+// escapePackagePath escapes a package name for a URL path, keeping the slash after the scope
+func escapePackagePath(name string) string {
+	parts := strings.Split(name, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return strings.Join(parts, "/")
+}
@@ -0,0 +1,134 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ktr0731/go-fuzzyfinder"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/term"
+
+	"github.com/LajnaLegenden/transpiler4/helpers"
+	"github.com/LajnaLegenden/transpiler4/logsocket"
+)
+
+// runningSessionAction is what watch does when another session runs for the same folder
+type runningSessionAction string
+
+const (
+	actionAttach   runningSessionAction = "attach"    // Follow the logs of the running session
+	actionTakeOver runningSessionAction = "take-over" // Stop the running session and start this one
+	actionOthers   runningSessionAction = "others"    // Watch the packages the running session doesn't watch
+)
+
+var runningSessionActions = []runningSessionAction{actionAttach, actionTakeOver, actionOthers}
+
+// takeOverTimeout is how long watch waits for the running session to stop when taking over
+const takeOverTimeout = 2 * time.Minute
+
+// chooseRunningSessionAction returns the action given with --if-running, or
+// asks for one with the fuzzy finder
+func chooseRunningSessionAction(c *cli.Context, running *helpers.SessionLock) (runningSessionAction, error) {
+	if value := c.String("if-running"); value != "" {
+		action := runningSessionAction(value)
+		if !slices.Contains(runningSessionActions, action) {
+			return "", fmt.Errorf("invalid --if-running %q, use attach, take-over or others", value)
+		}
+		return action, nil
+	}
+
+	description := fmt.Sprintf("mtcli watch is already running for this folder (pid %d, watching %s)", running.PID, describeList(running.Packages))
+	if !term.IsTerminal(int(os.Stdin.Fd())) || !term.IsTerminal(int(os.Stdout.Fd())) {
+		return "", fmt.Errorf("%s, use --if-running attach, take-over or others", description)
+	}
+	labels := map[runningSessionAction]string{
+		actionAttach:   "Follow the logs of the running session",
+		actionTakeOver: "Stop the running session and start this one",
+		actionOthers:   "Watch the packages the running session doesn't watch",
+	}
+	index, err := fuzzyfinder.Find(runningSessionActions, func(i int) string {
+		return labels[runningSessionActions[i]]
+	}, fuzzyfinder.WithHeader(description))
+	if err != nil {
+		if errors.Is(err, fuzzyfinder.ErrAbort) {
+			return "", errors.New("mtcli watch is already running for this folder")
+		}
+		return "", err
+	}
+	return runningSessionActions[index], nil
+}
+
+// attachToSession prints the logs of the running session until it stops
+func attachToSession(running *helpers.SessionLock) error {
+	if running.Port == 0 {
+		return fmt.Errorf("the session running as pid %d has no log server to attach to", running.PID)
+	}
+	fmt.Printf("Following the logs of the session running as pid %d, press Ctrl-C to stop\n", running.PID)
+	err := logsocket.FollowLogs(sessionURL(running), func(message logsocket.LogMessage) {
+		fmt.Printf("[%s] %s", message.Package, message.Message)
+		if !strings.HasSuffix(message.Message, "\n") {
+			fmt.Println()
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("lost the connection to the running session: %w", err)
+	}
+	fmt.Println("The running session stopped")
+	return nil
+}
+
+// takeOverSession stops the running session and locks the folder once it exited
+func takeOverSession(rootPath string, running *helpers.SessionLock) (*helpers.SessionLock, error) {
+	if running.Port == 0 {
+		return nil, fmt.Errorf("the session running as pid %d can't be stopped from here, stop it with Ctrl-C", running.PID)
+	}
+	var response map[string]bool
+	if err := requestSession(sessionURL(running), http.MethodPost, "/api/stop", nil, &response); err != nil {
+		return nil, fmt.Errorf("failed to stop the session running as pid %d: %w", running.PID, err)
+	}
+	log.Printf("Waiting for the session running as pid %d to stop", running.PID)
+
+	deadline := time.Now().Add(takeOverTimeout)
+	for time.Now().Before(deadline) {
+		lock, err := helpers.AcquireSessionLock(rootPath)
+		var locked *helpers.SessionLockedError
+		if !errors.As(err, &locked) {
+			return lock, err
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	return nil, fmt.Errorf("the session running as pid %d did not stop within %s", running.PID, takeOverTimeout)
+}
+
+// sessionURL returns the URL of the log server of the running session
+func sessionURL(running *helpers.SessionLock) string {
+	return logsocket.ServerURL(running.Bind, running.Port)
+}
+
+// excludeWatched leaves out the packages watched by other sessions, keyed by
+// package name with the pid of the session watching it
+func excludeWatched(packages []helpers.NodePackage, watched map[string]int) []helpers.NodePackage {
+	remaining := []helpers.NodePackage{}
+	for _, pkg := range packages {
+		if pid, ok := watched[pkg.PackageJson.Name]; ok {
+			log.Printf("Leaving out %s, the session running as pid %d watches it", pkg.PackageJson.Name, pid)
+			continue
+		}
+		remaining = append(remaining, pkg)
+	}
+	return remaining
+}
+
+// describeList joins names for a message, or says there are none
+func describeList(names []string) string {
+	if len(names) == 0 {
+		return "no packages"
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,165 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"runtime"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/LajnaLegenden/transpiler4/helpers"
+	"github.com/urfave/cli/v2"
+)
+
+// packageSelectionFlags returns the flags that select packages without the fuzzy finder
+func packageSelectionFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringSliceFlag{
+			Name:    "package",
+			Aliases: []string{"P"},
+			Usage:   "Select a package by name or glob, can be repeated",
+		},
+		&cli.BoolFlag{
+			Name:  "all",
+			Usage: "Select all buildable packages",
+		},
+		&cli.StringSliceFlag{
+			Name:  "strategy",
+			Usage: "Only select packages with this strategy, can be repeated",
+		},
+		&cli.BoolFlag{
+			Name:  "frontend",
+			Usage: "Only select frontend packages",
+		},
+		&cli.StringSliceFlag{
+			Name:  "exclude",
+			Usage: "Leave out packages matching this name or glob, can be repeated",
+		},
+		&cli.StringFlag{
+			Name:  "profile",
+			Usage: "Select the packages of a profile from the profiles in the root .mtcli.yaml",
+		},
+		&cli.BoolFlag{
+			Name:  "last",
+			Usage: "Select the packages selected last time",
+		},
+	}
+}
+
+// selectPackages picks packages from the selector flags, or with the fuzzy finder when none are given.
+// The selection is remembered for --last and for the top of the finder next time.
+func selectPackages(c *cli.Context, packages []helpers.NodePackage) ([]helpers.NodePackage, error) {
+	if len(packages) == 0 {
+		return nil, errors.New("no buildable packages found")
+	}
+	rootPath := packages[0].RootPath
+	last, err := helpers.LoadLastSelection(rootPath)
+	if err != nil {
+		log.Printf("Failed to read the last selection: %v", err)
+	}
+	// Packages can be renamed or removed since they were selected
+	last = slices.DeleteFunc(last, func(name string) bool {
+		return !slices.ContainsFunc(packages, func(pkg helpers.NodePackage) bool { return pkg.PackageJson.Name == name })
+	})
+
+	selector := helpers.PackageSelector{
+		Packages:   c.StringSlice("package"),
+		All:        c.Bool("all"),
+		Strategies: c.StringSlice("strategy"),
+		Frontend:   c.Bool("frontend"),
+		Exclude:    c.StringSlice("exclude"),
+	}
+	if c.Bool("last") {
+		if len(last) == 0 {
+			return nil, errors.New("no previous selection to use with --last")
+		}
+		selector.Names = last
+	}
+	if name := c.String("profile"); name != "" {
+		profile, err := loadProfile(rootPath, name)
+		if err != nil {
+			return nil, err
+		}
+		selector.Packages = append(selector.Packages, profile...)
+	}
+
+	var selected []helpers.NodePackage
+	if selector.IsSet() {
+		selected, err = helpers.FilterPackages(packages, selector)
+	} else {
+		selected, err = selectWithFinder(rootPath, packages, last)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := helpers.SaveLastSelection(rootPath, selected); err != nil {
+		log.Printf("Failed to remember the selection: %v", err)
+	}
+	return selected, nil
+}
+
+// selectWithFinder shows the fuzzy finder with the last selection and the
+// profiles on top, followed by the packages selected last time
+func selectWithFinder(rootPath string, packages []helpers.NodePackage, last []string) ([]helpers.NodePackage, error) {
+	var shortcuts []helpers.SelectionShortcut
+	// The finder can't preselect entries, the previous choice is the entry the cursor starts on instead
+	if len(last) > 0 {
+		shortcuts = append(shortcuts, helpers.SelectionShortcut{Label: "Last selection", Names: last})
+	}
+	config, err := helpers.LoadRootConfig(rootPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range sortedProfileNames(config) {
+		if len(config.Profiles[name]) == 0 {
+			continue
+		}
+		shortcuts = append(shortcuts, helpers.SelectionShortcut{Label: "Profile " + name, Packages: config.Profiles[name]})
+	}
+
+	ordered := slices.Clone(packages)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return slices.Contains(last, ordered[i].PackageJson.Name) && !slices.Contains(last, ordered[j].PackageJson.Name)
+	})
+	return helpers.SelectPackages(ordered, shortcuts...)
+}
+
+// loadProfile returns the package names and globs of a profile in the root config
+func loadProfile(rootPath string, name string) ([]string, error) {
+	config, err := helpers.LoadRootConfig(rootPath)
+	if err != nil {
+		return nil, err
+	}
+	profile, ok := config.Profiles[name]
+	if !ok {
+		if len(config.Profiles) == 0 {
+			return nil, fmt.Errorf("unknown profile %q, no profiles are defined in the root %s", name, helpers.ConfigFileName)
+		}
+		return nil, fmt.Errorf("unknown profile %q, available profiles: %s", name, strings.Join(sortedProfileNames(config), ", "))
+	}
+	if len(profile) == 0 {
+		return nil, fmt.Errorf("profile %q selects no packages", name)
+	}
+	return profile, nil
+}
+
+func sortedProfileNames(config *helpers.RootConfig) []string {
+	names := make([]string, 0, len(config.Profiles))
+	for name := range config.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// jobsFlag returns the flag limiting how many builds run at the same time
+func jobsFlag() cli.Flag {
+	return &cli.IntFlag{
+		Name:    "jobs",
+		Aliases: []string{"j"},
+		Usage:   "Maximum number of packages to build at the same time",
+		Value:   runtime.NumCPU(),
+	}
+}
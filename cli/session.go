@@ -0,0 +1,616 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/LajnaLegenden/transpiler4/helpers"
+	"github.com/LajnaLegenden/transpiler4/logsocket"
+)
+
+// watchSession holds what the watchers of all selected packages share
+type watchSession struct {
+	watcher      helpers.PackageWatcher
+	poller       *helpers.PollWatcher // Used for packages the watcher can't watch
+	scheduler    *helpers.Scheduler
+	rootPath     string // The mediatool root, whose other sessions' packages can't be added
+	webappPath   string
+	cascade      bool // Rebuild dependents after a package was rebuilt
+	initialBuild bool // Build packages when watching them starts
+	finishBuilds bool // Let running builds finish when the session stops
+	newLogger    func(name string) *log.Logger
+	stop         func() // Stops the session like the first Ctrl-C
+	stopChan     <-chan struct{}
+	lock         *helpers.SessionLock // Lists the watched packages for other mtcli instances
+	wg           sync.WaitGroup
+
+	mu         sync.Mutex // Guards the fields below
+	stopped    bool
+	buildable  []helpers.NodePackage    // Every package that can be added to the session
+	buildGraph *helpers.DependencyGraph // Dependencies between all buildable packages
+	graph      *helpers.DependencyGraph // Dependencies between the watched packages
+	packages   map[string]*watchedPackage
+}
+
+// attach starts watching and building pkg. Reports false if it is already
+// watched or the session is stopping.
+func (s *watchSession) attach(pkg helpers.NodePackage) bool {
+	name := pkg.PackageJson.Name
+	// Created before locking, the dashboard locks itself to hand out log writers
+	logger := s.newLogger(name)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped || s.packages[name] != nil {
+		return false
+	}
+	watched := newWatchedPackage(pkg, logger)
+	s.packages[name] = watched
+	s.updateGraph()
+	s.saveLock()
+	s.wg.Add(1)
+	go s.run(watched)
+	return true
+}
+
+// run watches a package until it stops. A package whose name changed in its
+// package.json is attached again under the new name once the old one stopped
+// watching, and built right away.
+func (s *watchSession) run(watched *watchedPackage) {
+	defer s.wg.Done()
+	renamed := watchForChanges(s, watched)
+	close(watched.finished)
+	if renamed == nil || !s.attach(*renamed) || s.initialBuild {
+		return
+	}
+	if next, err := s.lookup(renamed.PackageJson.Name); err == nil {
+		next.requestBuild()
+	}
+}
+
+// detach stops watching and building the package called name, cancelling its
+// running build. Returns once the build exited, so it no longer copies
+// outputs afterwards. The other packages are not affected.
+func (s *watchSession) detach(name string) bool {
+	s.mu.Lock()
+	watched, ok := s.packages[name]
+	if !ok {
+		s.mu.Unlock()
+		return false
+	}
+	delete(s.packages, name)
+	s.updateGraph()
+	s.saveLock()
+	close(watched.detached)
+	s.mu.Unlock()
+
+	<-watched.finished
+	return true
+}
+
+// updatePackage replaces the metadata of a watched package after it was
+// reloaded, keeping its name. Builds started afterwards use pkg.
+func (s *watchSession) updatePackage(watched *watchedPackage, pkg helpers.NodePackage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	watched.mu.Lock()
+	watched.pkg = pkg
+	watched.mu.Unlock()
+	s.replaceBuildable(pkg)
+	s.updateGraph()
+}
+
+// rename removes a watched package whose name changed, so it can be attached
+// again under the new name. Reports false if another watched package already
+// has that name.
+func (s *watchSession) rename(watched *watchedPackage, pkg helpers.NodePackage) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, taken := s.packages[pkg.PackageJson.Name]; taken {
+		return false
+	}
+	delete(s.packages, watched.pkg.PackageJson.Name)
+	s.replaceBuildable(pkg)
+	s.updateGraph()
+	s.saveLock()
+	return true
+}
+
+// replaceBuildable swaps the buildable package in the folder of pkg for pkg
+// and updates the dependencies. Must be called with s.mu held.
+func (s *watchSession) replaceBuildable(pkg helpers.NodePackage) {
+	buildable := make([]helpers.NodePackage, 0, len(s.buildable))
+	for _, other := range s.buildable {
+		if other.Path == pkg.Path {
+			other = pkg
+		}
+		buildable = append(buildable, other)
+	}
+	s.buildable = buildable
+	s.buildGraph = helpers.NewDependencyGraph(buildable)
+}
+
+// updateGraph limits the graph to the watched packages. Must be called with s.mu held.
+func (s *watchSession) updateGraph() {
+	packages := make([]helpers.NodePackage, 0, len(s.packages))
+	for _, watched := range s.packages {
+		packages = append(packages, watched.pkg)
+	}
+	s.graph = s.buildGraph.Subgraph(packages)
+}
+
+// saveLock writes the watched packages to the session lock. Must be called with s.mu held.
+func (s *watchSession) saveLock() {
+	if s.lock == nil {
+		return
+	}
+	names := make([]string, 0, len(s.packages))
+	for name := range s.packages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	s.lock.Packages = names
+	if err := s.lock.Save(); err != nil {
+		log.Printf("Failed to update the session lock: %v", err)
+	}
+}
+
+// wait blocks until the session is stopped and every package stopped watching
+func (s *watchSession) wait() {
+	<-s.stopChan
+	s.mu.Lock()
+	s.stopped = true
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// summary returns the last build result of every watched package sorted by
+// name. Packages that were not built are reported as skipped.
+func (s *watchSession) summary() []*helpers.BuildResult {
+	packages := s.sortedPackages()
+	results := make([]*helpers.BuildResult, 0, len(packages))
+	for _, watched := range packages {
+		result := watched.state().LastResult
+		if result == nil {
+			watched.mu.Lock()
+			result = helpers.NewBuildResult(watched.pkg)
+			watched.mu.Unlock()
+			result.Skipped = true
+			result.Error = "not built in this session"
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// watchPackage starts watching a package. Packages that can't be watched
+// because the watch limit is reached are polled instead.
+func (s *watchSession) watchPackage(watched *watchedPackage, ignore *helpers.IgnoreMatcher) (<-chan helpers.WatchEvent, error) {
+	events, err := s.watcher.Add(watched.pkg.Path, ignore)
+	if errors.Is(err, helpers.ErrWatchLimit) {
+		watched.logger.Printf("Can't watch for file system events: %v", err)
+		watched.logger.Printf("Polling %s for changes every %s instead", watched.pkg.PackageJson.Name, s.poller.Interval())
+		return s.poller.Add(watched.pkg.Path, ignore)
+	}
+	return events, err
+}
+
+// unwatchPackage stops watching a package started with watchPackage
+func (s *watchSession) unwatchPackage(watched *watchedPackage) {
+	s.watcher.Remove(watched.pkg.Path)
+	s.poller.Remove(watched.pkg.Path)
+}
+
+// cascadeBuild queues builds of the selected packages that depend on name,
+// which bundle the outputs that were just rebuilt
+func (s *watchSession) cascadeBuild(name string) {
+	if !s.cascade {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, dependent := range s.graph.Dependents(name) {
+		watched, ok := s.packages[dependent]
+		if !ok {
+			continue
+		}
+		if watched.triggerBuild() {
+			watched.logger.Printf("Rebuilding %s because its dependency %s was rebuilt", dependent, name)
+		}
+	}
+}
+
+// watchRootConfig reloads every watched package when the root .mtcli.yaml
+// changes, since its build settings, package settings and strategies apply to
+// all of them. It is a single file, so it is polled even when file system
+// events are available. Returns when the session stops.
+func (s *watchSession) watchRootConfig(rootPath string) {
+	defer s.wg.Done()
+	path := filepath.Join(rootPath, helpers.ConfigFileName)
+	stat := func() (int64, time.Time, bool) {
+		info, err := os.Stat(path)
+		if err != nil {
+			return 0, time.Time{}, false
+		}
+		return info.Size(), info.ModTime(), true
+	}
+	size, modTime, exists := stat()
+
+	ticker := time.NewTicker(s.poller.Interval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stopChan:
+			return
+		case <-ticker.C:
+		}
+		newSize, newModTime, newExists := stat()
+		if newSize == size && newModTime.Equal(modTime) && newExists == exists {
+			continue
+		}
+		size, modTime, exists = newSize, newModTime, newExists
+		s.reloadRootConfig(rootPath)
+	}
+}
+
+// reloadRootConfig registers the strategies of the changed root config and
+// reloads the watched packages, which read their settings from it again
+func (s *watchSession) reloadRootConfig(rootPath string) {
+	config, err := helpers.LoadRootConfig(rootPath)
+	if err != nil {
+		log.Printf("Failed to read the changed %s, building as before: %v", helpers.ConfigFileName, err)
+		return
+	}
+	if err := helpers.RegisterConfigStrategies(config); err != nil {
+		log.Printf("Failed to register the strategies of the changed %s: %v", helpers.ConfigFileName, err)
+	}
+	log.Printf("%s changed, reloading the watched packages", helpers.ConfigFileName)
+	for _, watched := range s.sortedPackages() {
+		watched.requestReload()
+	}
+}
+
+// sortedPackages returns the watched packages sorted by name
+func (s *watchSession) sortedPackages() []*watchedPackage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	packages := make([]*watchedPackage, 0, len(s.packages))
+	for _, watched := range s.packages {
+		packages = append(packages, watched)
+	}
+	sort.Slice(packages, func(i, j int) bool {
+		return packages[i].pkg.PackageJson.Name < packages[j].pkg.PackageJson.Name
+	})
+	return packages
+}
+
+// Packages implements logsocket.Controller
+func (s *watchSession) Packages() []logsocket.PackageState {
+	packages := s.sortedPackages()
+	states := make([]logsocket.PackageState, 0, len(packages))
+	for _, watched := range packages {
+		state := watched.state()
+		states = append(states, logsocket.PackageState{
+			Name:       state.Name,
+			Status:     string(state.Status),
+			Paused:     state.Paused,
+			BuildingMs: state.Building.Milliseconds(),
+			LastResult: state.LastResult,
+		})
+	}
+	return states
+}
+
+// Rebuild implements logsocket.Controller
+func (s *watchSession) Rebuild(name string) error {
+	watched, err := s.lookup(name)
+	if err != nil {
+		return err
+	}
+	watched.logger.Printf("Rebuild of %s requested", name)
+	watched.requestBuild()
+	return nil
+}
+
+// Pause implements logsocket.Controller
+func (s *watchSession) Pause(name string) error {
+	watched, err := s.lookup(name)
+	if err != nil {
+		return err
+	}
+	watched.pause()
+	return nil
+}
+
+// Resume implements logsocket.Controller
+func (s *watchSession) Resume(name string) error {
+	watched, err := s.lookup(name)
+	if err != nil {
+		return err
+	}
+	watched.resume()
+	return nil
+}
+
+// Cancel implements logsocket.Controller
+func (s *watchSession) Cancel(name string) error {
+	watched, err := s.lookup(name)
+	if err != nil {
+		return err
+	}
+	if !watched.cancel() {
+		return fmt.Errorf("%s is not building", name)
+	}
+	return nil
+}
+
+// Add implements logsocket.Controller
+func (s *watchSession) Add(pattern string) ([]string, error) {
+	s.mu.Lock()
+	buildable := s.buildable
+	s.mu.Unlock()
+
+	// Another session started with --if-running others may build some of them
+	elsewhere, err := helpers.WatchedByOtherSessions(s.rootPath)
+	if err != nil {
+		log.Printf("Failed to read the packages of the other sessions: %v", err)
+	}
+
+	matched, added, skipped := 0, []string{}, []string{}
+	for _, pkg := range buildable {
+		name := pkg.PackageJson.Name
+		if !helpers.MatchPackageName(name, pattern) {
+			continue
+		}
+		matched++
+		if pid, ok := elsewhere[name]; ok {
+			log.Printf("Not adding %s, the session running as pid %d watches it", name, pid)
+			skipped = append(skipped, fmt.Sprintf("%s (pid %d)", name, pid))
+			continue
+		}
+		if s.attach(pkg) {
+			log.Printf("Added %s to the session", name)
+			added = append(added, name)
+		}
+	}
+	switch {
+	case matched == 0:
+		return nil, fmt.Errorf("%w %s", logsocket.ErrUnknownPackage, pattern)
+	case len(added) == 0 && len(skipped) > 0:
+		return nil, fmt.Errorf("%s is watched by another session: %s", pattern, strings.Join(skipped, ", "))
+	case len(added) == 0:
+		return nil, fmt.Errorf("%s is already watched", pattern)
+	}
+	return added, nil
+}
+
+// Remove implements logsocket.Controller
+func (s *watchSession) Remove(pattern string) ([]string, error) {
+	removed := []string{}
+	for _, watched := range s.sortedPackages() {
+		name := watched.name()
+		if helpers.MatchPackageName(name, pattern) && s.detach(name) {
+			log.Printf("Removed %s from the session", name)
+			removed = append(removed, name)
+		}
+	}
+	if len(removed) == 0 {
+		return nil, fmt.Errorf("%w %s", logsocket.ErrUnknownPackage, pattern)
+	}
+	return removed, nil
+}
+
+// Available implements logsocket.Controller
+func (s *watchSession) Available() []helpers.NodePackage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	available := []helpers.NodePackage{}
+	for _, pkg := range s.buildable {
+		if _, ok := s.packages[pkg.PackageJson.Name]; !ok {
+			available = append(available, pkg)
+		}
+	}
+	return available
+}
+
+// Stop implements logsocket.Controller
+func (s *watchSession) Stop() {
+	log.Printf("Stop requested through the log server")
+	s.stop()
+}
+
+// lookup returns the watched package called name
+func (s *watchSession) lookup(name string) (*watchedPackage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	watched, ok := s.packages[name]
+	if !ok {
+		return nil, fmt.Errorf("%w %s", logsocket.ErrUnknownPackage, name)
+	}
+	return watched, nil
+}
+
+// packageStatus is what a watched package is doing right now
+type packageStatus string
+
+const (
+	statusIdle     packageStatus = "idle"
+	statusQueued   packageStatus = "queued"
+	statusBuilding packageStatus = "building"
+	statusFailed   packageStatus = "failed"
+)
+
+// watchedPackage is a selected package with its own log and build queue
+type watchedPackage struct {
+	pkg        helpers.NodePackage // Only changed by its watch loop, with both mutexes held
+	logger     *log.Logger
+	buildChan  chan struct{} // Holds at most one pending build
+	reloadChan chan struct{} // Holds at most one pending reload
+	detached   chan struct{} // Closed when the package is removed from the session
+	finished   chan struct{} // Closed when its watch loop and build exited
+
+	mu                 sync.Mutex
+	status             packageStatus
+	paused             bool
+	changedWhilePaused bool
+	buildStarted       time.Time
+	cancelBuild        context.CancelFunc // Stops the running build
+	lastResult         *helpers.BuildResult
+}
+
+// packageState is a snapshot of a watched package for displaying it
+type packageState struct {
+	Name       string
+	Status     packageStatus
+	Paused     bool
+	Building   time.Duration // How long the running build has taken so far
+	LastResult *helpers.BuildResult
+}
+
+func newWatchedPackage(pkg helpers.NodePackage, logger *log.Logger) *watchedPackage {
+	return &watchedPackage{
+		pkg:        pkg,
+		logger:     logger,
+		buildChan:  make(chan struct{}, 1),
+		reloadChan: make(chan struct{}, 1),
+		detached:   make(chan struct{}),
+		finished:   make(chan struct{}),
+		status:     statusIdle,
+	}
+}
+
+// name returns the name of the package
+func (w *watchedPackage) name() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.pkg.PackageJson.Name
+}
+
+// requestBuild queues a build of the package unless one is already pending
+func (w *watchedPackage) requestBuild() bool {
+	select {
+	case w.buildChan <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// requestReload asks the watch loop to read the package again, like after a
+// change to its package.json, unless a reload is already pending
+func (w *watchedPackage) requestReload() {
+	select {
+	case w.reloadChan <- struct{}{}:
+	default:
+	}
+}
+
+// triggerBuild queues a build for a change. While the package is paused the
+// change is remembered and built when the package is resumed.
+func (w *watchedPackage) triggerBuild() bool {
+	w.mu.Lock()
+	if w.paused {
+		w.changedWhilePaused = true
+		w.mu.Unlock()
+		return false
+	}
+	w.mu.Unlock()
+	return w.requestBuild()
+}
+
+// pause stops changes from triggering builds
+func (w *watchedPackage) pause() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.paused {
+		w.paused = true
+		w.logger.Printf("Paused watching %s", w.pkg.PackageJson.Name)
+	}
+}
+
+// resume lets changes trigger builds again, and builds the changes made while paused
+func (w *watchedPackage) resume() {
+	w.mu.Lock()
+	wasPaused, changed := w.paused, w.changedWhilePaused
+	w.paused = false
+	w.changedWhilePaused = false
+	w.mu.Unlock()
+
+	if !wasPaused {
+		return
+	}
+	w.logger.Printf("Resumed watching %s", w.name())
+	if changed {
+		w.logger.Printf("Rebuilding %s for the changes made while paused", w.name())
+		w.requestBuild()
+	}
+}
+
+// cancel stops the running build. Reports false if nothing was building.
+func (w *watchedPackage) cancel() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.status != statusBuilding || w.cancelBuild == nil {
+		return false
+	}
+	w.logger.Printf("Cancelling the build of %s", w.pkg.PackageJson.Name)
+	w.cancelBuild()
+	return true
+}
+
+// setQueued marks the package as waiting for the scheduler
+func (w *watchedPackage) setQueued() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.status != statusBuilding {
+		w.status = statusQueued
+	}
+}
+
+// startBuild marks the package as building. cancel stops the build.
+func (w *watchedPackage) startBuild(cancel context.CancelFunc) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.status = statusBuilding
+	w.buildStarted = time.Now()
+	w.cancelBuild = cancel
+}
+
+// finishBuild records the result of the build started with startBuild
+func (w *watchedPackage) finishBuild(result *helpers.BuildResult) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.cancelBuild = nil
+	// A build cancelled before it started leaves the last result in place
+	if result != nil {
+		w.lastResult = result
+	}
+	w.status = statusIdle
+	if w.lastResult != nil && !w.lastResult.Success && !w.lastResult.Cancelled {
+		w.status = statusFailed
+	}
+}
+
+// state returns a snapshot of the package
+func (w *watchedPackage) state() packageState {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	state := packageState{
+		Name:       w.pkg.PackageJson.Name,
+		Status:     w.status,
+		Paused:     w.paused,
+		LastResult: w.lastResult,
+	}
+	if w.status == statusBuilding {
+		state.Building = time.Since(w.buildStarted)
+	}
+	return state
+}
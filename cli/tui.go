@@ -0,0 +1,491 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"golang.org/x/term"
+)
+
+// dashboardLogLines is how many log lines are kept per package
+const dashboardLogLines = 1000
+
+// ansiEscape matches the color and cursor codes build tools write to their output
+var ansiEscape = regexp.MustCompile(`\x1b\[[0-9;?]*[ -/]*[@-~]`)
+
+// dashboard is the full screen view of watch mode enabled with --tui
+type dashboard struct {
+	screen    tcell.Screen
+	session   *watchSession
+	packages  []*watchedPackage // The watched packages at the last redraw
+	systemLog *logBuffer
+	logs      map[string]*logBuffer // Keyed by package name
+	viewerURL string
+	stop      func() // Called again to exit without waiting for the running builds
+	dirty     atomic.Bool
+	done      chan struct{}
+
+	mu         sync.Mutex
+	selected   int
+	showSystem bool // Show the System log instead of the selected package's log
+	scroll     int  // Lines scrolled up from the end of the log
+	message    string
+	stopping   bool
+}
+
+// newDashboard takes over the terminal. stop is called when the user quits.
+func newDashboard(viewerURL string, stop func()) (*dashboard, error) {
+	if !term.IsTerminal(int(os.Stdin.Fd())) || !term.IsTerminal(int(os.Stdout.Fd())) {
+		return nil, fmt.Errorf("--tui needs a terminal")
+	}
+	screen, err := tcell.NewScreen()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the terminal: %w", err)
+	}
+	if err := screen.Init(); err != nil {
+		return nil, fmt.Errorf("failed to open the terminal: %w", err)
+	}
+	d := &dashboard{
+		screen:    screen,
+		viewerURL: viewerURL,
+		stop:      stop,
+		logs:      make(map[string]*logBuffer),
+		done:      make(chan struct{}),
+	}
+	d.systemLog = newLogBuffer(d.markDirty)
+	return d, nil
+}
+
+// packageLogWriter returns the writer for the log of a package
+func (d *dashboard) packageLogWriter(name string) io.Writer {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	// A package added again keeps its earlier log
+	if buffer, ok := d.logs[name]; ok {
+		return buffer
+	}
+	buffer := newLogBuffer(d.markDirty)
+	d.logs[name] = buffer
+	return buffer
+}
+
+// run shows the packages of session until close is called
+func (d *dashboard) run(session *watchSession) {
+	d.mu.Lock()
+	d.session = session
+	d.mu.Unlock()
+	d.draw()
+
+	go func() {
+		// Redraw for new log lines, and every second for the build timers
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		ticks := 0
+		for {
+			select {
+			case <-d.done:
+				return
+			case <-ticker.C:
+				ticks++
+				if d.dirty.Swap(false) || ticks%10 == 0 {
+					d.draw()
+				}
+			}
+		}
+	}()
+
+	for {
+		event := d.screen.PollEvent()
+		switch event := event.(type) {
+		case nil:
+			// The screen was closed
+			return
+		case *tcell.EventResize:
+			d.screen.Sync()
+			d.draw()
+		case *tcell.EventKey:
+			d.handleKey(event)
+			d.draw()
+		}
+	}
+}
+
+// close gives the terminal back
+func (d *dashboard) close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	select {
+	case <-d.done:
+		return
+	default:
+	}
+	close(d.done)
+	d.screen.Fini()
+}
+
+func (d *dashboard) markDirty() {
+	d.dirty.Store(true)
+}
+
+func (d *dashboard) handleKey(event *tcell.EventKey) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.message = ""
+	d.refreshPackages()
+	var selected *watchedPackage
+	if len(d.packages) > 0 {
+		selected = d.packages[d.selected]
+	}
+
+	switch event.Key() {
+	case tcell.KeyUp:
+		d.moveSelection(-1)
+		return
+	case tcell.KeyDown:
+		d.moveSelection(1)
+		return
+	case tcell.KeyPgUp:
+		d.scroll += 10
+		return
+	case tcell.KeyPgDn:
+		d.scroll = max(d.scroll-10, 0)
+		return
+	case tcell.KeyCtrlC:
+		d.requestStop()
+		return
+	}
+
+	if selected == nil && strings.ContainsRune("rpcx", event.Rune()) {
+		d.message = "No packages are watched, add them with 'mtcli watch add'"
+		return
+	}
+
+	switch event.Rune() {
+	case 'k':
+		d.moveSelection(-1)
+	case 'j':
+		d.moveSelection(1)
+	case 'r':
+		selected.logger.Printf("Rebuild of %s requested", selected.name())
+		if !selected.requestBuild() {
+			d.message = selected.name() + " is already queued"
+		}
+	case 'R':
+		for _, watched := range d.packages {
+			watched.logger.Printf("Rebuild of %s requested", watched.name())
+			watched.requestBuild()
+		}
+	case 'p':
+		if selected.state().Paused {
+			selected.resume()
+		} else {
+			selected.pause()
+		}
+	case 'P':
+		// Pause everything, or resume everything if all packages are paused
+		allPaused := true
+		for _, watched := range d.packages {
+			allPaused = allPaused && watched.state().Paused
+		}
+		for _, watched := range d.packages {
+			if allPaused {
+				watched.resume()
+			} else {
+				watched.pause()
+			}
+		}
+	case 'c':
+		if !selected.cancel() {
+			d.message = selected.name() + " is not building"
+		}
+	case 'x':
+		name := selected.name()
+		d.message = "Removing " + name
+		// detach waits for the build to exit, which logs to the dashboard and needs d.mu
+		go func() {
+			if !d.session.detach(name) {
+				return
+			}
+			log.Printf("Removed %s from the session", name)
+			d.mu.Lock()
+			d.message = fmt.Sprintf("Removed %s, add it again with 'mtcli watch add %s'", name, name)
+			d.refreshPackages()
+			d.mu.Unlock()
+			d.markDirty()
+		}()
+	case 'l':
+		d.showSystem = !d.showSystem
+		d.scroll = 0
+	case 'o':
+		if err := openBrowser(d.viewerURL); err != nil {
+			d.message = fmt.Sprintf("Failed to open %s: %v", d.viewerURL, err)
+		} else {
+			d.message = "Opened " + d.viewerURL
+		}
+	case 'q':
+		d.requestStop()
+	}
+}
+
+// requestStop stops the session, or exits right away when it is already
+// stopping. Must be called with d.mu held.
+func (d *dashboard) requestStop() {
+	if d.stopping {
+		d.message = "Exiting..."
+	} else {
+		d.message = "Stopping, press Ctrl-C again to exit right away"
+	}
+	d.stopping = true
+	// Exiting closes the dashboard, which needs d.mu
+	go d.stop()
+}
+
+// refreshPackages picks up the packages added to or removed from the session,
+// keeping the selected package selected. Must be called with d.mu held.
+func (d *dashboard) refreshPackages() {
+	selectedName := ""
+	if d.selected < len(d.packages) {
+		selectedName = d.packages[d.selected].name()
+	}
+	d.packages = d.session.sortedPackages()
+	d.selected = min(d.selected, max(len(d.packages)-1, 0))
+	for i, watched := range d.packages {
+		if watched.name() == selectedName {
+			d.selected = i
+		}
+	}
+}
+
+// moveSelection selects another package. Must be called with d.mu held.
+func (d *dashboard) moveSelection(delta int) {
+	if len(d.packages) == 0 {
+		return
+	}
+	d.selected = (d.selected + delta + len(d.packages)) % len(d.packages)
+	d.showSystem = false
+	d.scroll = 0
+}
+
+func (d *dashboard) draw() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	select {
+	case <-d.done:
+		return
+	default:
+	}
+
+	d.refreshPackages()
+	d.screen.Clear()
+	width, height := d.screen.Size()
+	bold := tcell.StyleDefault.Bold(true)
+	dim := tcell.StyleDefault.Foreground(tcell.ColorGray)
+
+	states := make([]packageState, len(d.packages))
+	building, queued := 0, 0
+	for i, watched := range d.packages {
+		states[i] = watched.state()
+		switch states[i].Status {
+		case statusBuilding:
+			building++
+		case statusQueued:
+			queued++
+		}
+	}
+
+	row := 0
+	header := fmt.Sprintf(" mtcli watch  %d packages  %d building  %d queued  %s", len(d.packages), building, queued, d.viewerURL)
+	d.drawText(0, row, width, header, bold.Reverse(true), true)
+	row++
+	d.drawText(0, row, width, fmt.Sprintf("   %-40s %-10s %-10s %s", "Package", "Status", "Duration", "Last build"), bold, false)
+	row++
+
+	// Keep the log pane at least a third of the screen
+	maxRows := max(height-row-2-height/3, 1)
+	first := 0
+	if d.selected >= maxRows {
+		first = d.selected - maxRows + 1
+	}
+	for i := first; i < len(states) && i < first+maxRows; i++ {
+		d.drawPackageRow(row, width, states[i], i == d.selected && !d.showSystem)
+		row++
+	}
+
+	// Log pane
+	var title string
+	var lines []string
+	if len(d.packages) > 0 {
+		title = " Log: " + d.packages[d.selected].name() + " "
+		lines = d.logs[d.packages[d.selected].name()].Lines()
+	}
+	if d.showSystem || len(d.packages) == 0 {
+		title = " Log: System "
+		lines = d.systemLog.Lines()
+	}
+	d.drawText(0, row, width, "─"+title+strings.Repeat("─", max(width-len(title)-1, 0)), dim, false)
+	row++
+
+	logHeight := max(height-row-1, 0)
+	d.scroll = min(d.scroll, max(len(lines)-logHeight, 0))
+	end := len(lines) - d.scroll
+	start := max(end-logHeight, 0)
+	for _, line := range lines[start:end] {
+		d.drawText(0, row, width, line, tcell.StyleDefault, false)
+		row++
+	}
+
+	footer := " ↑/↓ select  r rebuild  R rebuild all  p pause  P pause all  c cancel  x remove  l system log  o open viewer  PgUp/PgDn scroll  q quit"
+	if d.message != "" {
+		footer = " " + d.message
+	}
+	d.drawText(0, height-1, width, footer, dim.Reverse(true), true)
+	d.screen.Show()
+}
+
+func (d *dashboard) drawPackageRow(row int, width int, state packageState, selected bool) {
+	statusStyle := tcell.StyleDefault
+	switch state.Status {
+	case statusBuilding:
+		statusStyle = statusStyle.Foreground(tcell.ColorYellow)
+	case statusQueued:
+		statusStyle = statusStyle.Foreground(tcell.ColorBlue)
+	case statusFailed:
+		statusStyle = statusStyle.Foreground(tcell.ColorRed)
+	default:
+		statusStyle = statusStyle.Foreground(tcell.ColorGreen)
+	}
+
+	marker := "  "
+	nameStyle := tcell.StyleDefault
+	if selected {
+		marker = "> "
+		nameStyle = nameStyle.Bold(true)
+	}
+	status := string(state.Status)
+	if state.Paused && (state.Status == statusIdle || state.Status == statusFailed) {
+		status = "paused"
+	}
+
+	duration, details := "", ""
+	if state.Status == statusBuilding {
+		duration = state.Building.Round(time.Second).String()
+	} else if state.LastResult != nil {
+		result := state.LastResult
+		duration = result.Duration().Round(time.Millisecond).String()
+		details = result.StartedAt.Format("15:04:05")
+		switch {
+		case result.Cancelled:
+			details += " cancelled"
+		case !result.Success:
+			details += " " + describeFailure(result)
+		case result.Cached:
+			details += " cached, " + summarizeArtifacts(result.Artifacts)
+		default:
+			details += " " + summarizeArtifacts(result.Artifacts)
+		}
+	}
+
+	details = cleanLogLine(details)
+
+	x := d.drawText(0, row, width, fmt.Sprintf(" %s%-40s ", marker, state.Name), nameStyle, false)
+	x += d.drawText(x, row, width-x, fmt.Sprintf("%-10s ", status), statusStyle, false)
+	d.drawText(x, row, width-x, fmt.Sprintf("%-10s %s", duration, details), tcell.StyleDefault, false)
+}
+
+// drawText writes text at x, y cut off at width characters and returns how many
+// cells were used. fill pads the rest of the width with the style.
+func (d *dashboard) drawText(x int, y int, width int, text string, style tcell.Style, fill bool) int {
+	used := 0
+	for _, r := range text {
+		if used >= width {
+			break
+		}
+		d.screen.SetContent(x+used, y, r, nil, style)
+		used++
+	}
+	if fill {
+		for ; used < width; used++ {
+			d.screen.SetContent(x+used, y, ' ', nil, style)
+		}
+	}
+	return used
+}
+
+// logBuffer keeps the last lines written to it, without color codes
+type logBuffer struct {
+	mu       sync.Mutex
+	lines    []string
+	partial  string
+	onChange func()
+}
+
+func newLogBuffer(onChange func()) *logBuffer {
+	return &logBuffer{onChange: onChange}
+}
+
+// Write implements io.Writer
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	text := b.partial + string(p)
+	parts := strings.Split(text, "\n")
+	b.partial = parts[len(parts)-1]
+	for _, line := range parts[:len(parts)-1] {
+		b.lines = append(b.lines, cleanLogLine(line))
+	}
+	if len(b.lines) > dashboardLogLines {
+		b.lines = b.lines[len(b.lines)-dashboardLogLines:]
+	}
+	b.mu.Unlock()
+	b.onChange()
+	return len(p), nil
+}
+
+// Lines returns the kept lines
+func (b *logBuffer) Lines() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	lines := append([]string{}, b.lines...)
+	if b.partial != "" {
+		lines = append(lines, cleanLogLine(b.partial))
+	}
+	return lines
+}
+
+// cleanLogLine removes color codes and keeps what a terminal would show for
+// progress output that redraws the line with \r
+func cleanLogLine(line string) string {
+	line = ansiEscape.ReplaceAllString(line, "")
+	line = strings.TrimRight(line, "\r")
+	if index := strings.LastIndexByte(line, '\r'); index != -1 {
+		line = line[index+1:]
+	}
+	return strings.ReplaceAll(line, "\t", "    ")
+}
+
+// openBrowser opens url in the default browser
+func openBrowser(url string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", url)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go cmd.Wait()
+	return nil
+}
@@ -0,0 +1,63 @@
+package cli
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLogBuffer(t *testing.T) {
+	tests := []struct {
+		name     string
+		writes   []string
+		expected []string
+	}{
+		{"one line", []string{"built\n"}, []string{"built"}},
+		{"several lines in one write", []string{"a\nb\nc\n"}, []string{"a", "b", "c"}},
+		{"line split across writes", []string{"bui", "lt\nne", "xt\n"}, []string{"built", "next"}},
+		{"unfinished line", []string{"done\nwaiting"}, []string{"done", "waiting"}},
+		{"empty line", []string{"a\n\nb\n"}, []string{"a", "", "b"}},
+		{"color codes", []string{"\x1b[32m✓\x1b[0m built\n"}, []string{"✓ built"}},
+		{"color code split across writes", []string{"\x1b[3", "1merror\x1b[0m\n"}, []string{"error"}},
+		{"progress redrawn with \\r", []string{"10%\r50%\r100%\n"}, []string{"100%"}},
+		{"windows line endings", []string{"a\r\nb\r\n"}, []string{"a", "b"}},
+		{"tabs", []string{"\tat index.ts\n"}, []string{"    at index.ts"}},
+	}
+	for _, test := range tests {
+		changes := 0
+		buffer := newLogBuffer(func() { changes++ })
+		for _, write := range test.writes {
+			if n, err := buffer.Write([]byte(write)); n != len(write) || err != nil {
+				t.Errorf("%s: expected %d bytes to be written, got %d: %v", test.name, len(write), n, err)
+			}
+		}
+		if lines := buffer.Lines(); !reflect.DeepEqual(lines, test.expected) {
+			t.Errorf("%s: expected lines %q, got %q", test.name, test.expected, lines)
+		}
+		if changes != len(test.writes) {
+			t.Errorf("%s: expected %d changes to be reported, got %d", test.name, len(test.writes), changes)
+		}
+	}
+}
+
+func TestLogBufferKeepsLastLines(t *testing.T) {
+	buffer := newLogBuffer(func() {})
+	var text strings.Builder
+	for i := 0; i < dashboardLogLines+250; i++ {
+		fmt.Fprintf(&text, "line %d\n", i)
+	}
+	buffer.Write([]byte(text.String()))
+	buffer.Write([]byte("last\n"))
+
+	lines := buffer.Lines()
+	if len(lines) != dashboardLogLines {
+		t.Fatalf("Expected %d lines to be kept, got %d", dashboardLogLines, len(lines))
+	}
+	if first := fmt.Sprintf("line %d", 251); lines[0] != first {
+		t.Errorf("Expected the oldest lines to be dropped, got %q first", lines[0])
+	}
+	if lines[len(lines)-1] != "last" {
+		t.Errorf("Expected the newest line last, got %q", lines[len(lines)-1])
+	}
+}
@@ -2,16 +2,19 @@ package cli
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
-	"github.com/fsnotify/fsnotify"
 	"github.com/urfave/cli/v2"
 
 	"github.com/LajnaLegenden/transpiler4/helpers"
@@ -24,7 +27,7 @@ func WatchCommand() *cli.Command {
 		Name:    "watch",
 		Aliases: []string{"w"},
 		Usage:   "Watch for changes and build and copy this project",
-		Flags: []cli.Flag{
+		Flags: append([]cli.Flag{
 			&cli.StringFlag{
 				Name:    "path",
 				Aliases: []string{"p"},
@@ -35,8 +38,46 @@ func WatchCommand() *cli.Command {
 				Aliases: []string{"n"},
 				Usage:   "Disable initial build when starting watch",
 			},
-		},
+			jobsFlag(),
+			&cli.BoolFlag{
+				Name:  "no-cascade",
+				Usage: "Don't rebuild selected packages that depend on a package after it was rebuilt",
+			},
+			&cli.BoolFlag{
+				Name:  "no-cache",
+				Usage: "Run the build commands even if the sources did not change",
+			},
+			&cli.BoolFlag{
+				Name:  "finish-builds",
+				Usage: "Let running builds finish when stopping instead of cancelling them",
+			},
+			&cli.IntFlag{
+				Name:  "port",
+				Usage: "Preferred port of the log server, a free one is used when it is busy (default: server.port of .mtcli.yaml, else 2999)",
+			},
+			&cli.StringFlag{
+				Name:  "bind",
+				Usage: "Address the log server listens on, 0.0.0.0 for every interface (default: server.bind of .mtcli.yaml, else 127.0.0.1)",
+			},
+			&cli.StringFlag{
+				Name:  "if-running",
+				Usage: "What to do when mtcli watch already runs for the folder: attach, take-over or others",
+			},
+			&cli.BoolFlag{
+				Name:  "tui",
+				Usage: "Show a full screen dashboard with the status and log of every package",
+			},
+			&cli.GenericFlag{
+				Name:  "poll",
+				Usage: "Scan for changes instead of using file system events, optionally with an interval like --poll=500ms",
+				Value: &pollFlag{},
+			},
+		}, packageSelectionFlags()...),
 		Action: WatchAction,
+		Subcommands: []*cli.Command{
+			watchAddCommand(),
+			watchRemoveCommand(),
+		},
 	}
 }
 
@@ -52,12 +93,52 @@ func WatchAction(c *cli.Context) error {
 		return fmt.Errorf("failed to get absolute path: %w", err)
 	}
 
-	// Start the log socket server
-	port, err := logsocket.StartServer()
+	helpers.SetBuildCacheEnabled(!c.Bool("no-cache"))
+
+	// Only one session builds into the webapp at a time
+	sessionLock, err := helpers.AcquireSessionLock(projectPath)
+	var running *helpers.SessionLockedError
+	joined := false // Building the packages the running session doesn't watch
+	if errors.As(err, &running) {
+		action, err := chooseRunningSessionAction(c, running.Lock)
+		if err != nil {
+			return err
+		}
+		switch action {
+		case actionAttach:
+			return attachToSession(running.Lock)
+		case actionTakeOver:
+			if sessionLock, err = takeOverSession(projectPath, running.Lock); err != nil {
+				return err
+			}
+		case actionOthers:
+			if sessionLock, err = helpers.JoinSessionLock(projectPath); err != nil {
+				return fmt.Errorf("failed to record the session in %s: %w", projectPath, err)
+			}
+			joined = true
+		}
+	} else if err != nil {
+		return fmt.Errorf("failed to lock %s: %w", projectPath, err)
+	}
+	defer sessionLock.Release()
+
+	rootConfig, err := helpers.LoadRootConfig(projectPath)
+	if err != nil {
+		return fmt.Errorf("failed to load %s: %w", helpers.ConfigFileName, err)
+	}
+	port, bind := logServerAddress(c, rootConfig.Server)
+	port, err = logsocket.StartServer(bind, port)
 	if err != nil {
 		return fmt.Errorf("failed to start log socket server: %w", err)
 	}
-	fmt.Printf("Log viewer available at http://localhost:%d\n", port)
+	viewerURL := logsocket.ServerURL(bind, port)
+	fmt.Printf("Log viewer available at %s\n", viewerURL)
+	// Lets other instances attach while packages are still being selected
+	sessionLock.Port = port
+	sessionLock.Bind = bind
+	if err := sessionLock.Save(); err != nil {
+		log.Printf("Failed to update the session lock: %v", err)
+	}
 
 	// Create a global log writer for non-package specific logs
 	originalLogger := log.Writer()
@@ -66,141 +147,429 @@ func WatchAction(c *cli.Context) error {
 
 	packages, err := helpers.FindNodePackages(projectPath)
 	if err != nil {
-		log.Fatal("Error selecting packages: ", err)
+		return fmt.Errorf("failed to find packages: %w", err)
 	}
 	buildablePackages := helpers.GetBuildablePackages(packages)
-	selectedPackages := helpers.SelectPackages(buildablePackages)
+	if joined {
+		watched, err := helpers.WatchedByOtherSessions(projectPath)
+		if err != nil {
+			return fmt.Errorf("failed to read the packages of the running sessions: %w", err)
+		}
+		buildablePackages = excludeWatched(buildablePackages, watched)
+	}
+	selectedPackages, err := selectPackages(c, buildablePackages)
+	if err != nil {
+		return err
+	}
 
-	var wg sync.WaitGroup
+	// Polling is used when asked for, or when file system events are not available
+	poll := c.Generic("poll").(*pollFlag)
+	poller := helpers.NewPollWatcher(poll.interval, log.Default())
+	defer poller.Close()
+	var packageWatcher helpers.PackageWatcher = poller
+	if poll.enabled {
+		log.Printf("Polling for changes every %s", poller.Interval())
+	} else if fileWatcher, err := helpers.NewFileWatcher(log.Default()); err != nil {
+		log.Printf("Failed to start watching for file system events, polling every %s instead: %v", poller.Interval(), err)
+	} else {
+		// One watcher for all packages keeps the number of inotify instances down
+		packageWatcher = fileWatcher
+		defer fileWatcher.Close()
+	}
+
+	// shutdown lets the watchers wind down
 	stopChan := make(chan struct{})
+	var shutdownOnce sync.Once
+	shutdown := func() {
+		shutdownOnce.Do(func() {
+			if c.Bool("finish-builds") {
+				log.Printf("Stopping once the running builds finish, press Ctrl-C again to exit right away")
+			} else {
+				log.Printf("Stopping, press Ctrl-C again to exit right away")
+			}
+			logsocket.SetController(nil)
+			close(stopChan)
+		})
+	}
+	// The first Ctrl-C shuts down, the second one exits right away
+	var stopCalls atomic.Int32
+	var dash *dashboard
+	stop := func() {
+		if stopCalls.Add(1) == 1 {
+			shutdown()
+			return
+		}
+		helpers.KillRunningCommands()
+		if dash != nil {
+			dash.close()
+		}
+		log.SetOutput(originalLogger)
+		logsocket.StopServer()
+		sessionLock.Release()
+		log.Printf("Exited without waiting for the running builds")
+		os.Exit(1)
+	}
+
+	// Package logs go to the dashboard instead of stdout with --tui
+	packageOutput := func(name string) io.Writer { return os.Stdout }
+	if c.Bool("tui") {
+		dash, err = newDashboard(viewerURL, stop)
+		if err != nil {
+			return err
+		}
+		defer dash.close()
+		log.SetOutput(logsocket.NewLogWriter(dash.systemLog, "System"))
+		packageOutput = dash.packageLogWriter
+	}
+
+	session := &watchSession{
+		watcher: packageWatcher,
+		poller:  poller,
+		// All packages share one scheduler so the number of parallel builds stays bounded
+		scheduler:    helpers.NewScheduler(c.Int("jobs"), log.Default()),
+		rootPath:     projectPath,
+		buildable:    buildablePackages,
+		buildGraph:   helpers.NewDependencyGraph(buildablePackages),
+		webappPath:   projectPath + "/webapp",
+		cascade:      !c.Bool("no-cascade"),
+		initialBuild: !c.Bool("no-build"),
+		finishBuilds: c.Bool("finish-builds"),
+		newLogger: func(name string) *log.Logger {
+			return log.New(logsocket.NewLogWriter(packageOutput(name), name), "", log.LstdFlags)
+		},
+		stop:     shutdown,
+		stopChan: stopChan,
+		lock:     sessionLock,
+		packages: make(map[string]*watchedPackage, len(selectedPackages)),
+	}
 
 	// Set up signal handling
 	signalChan := make(chan os.Signal, 1)
 	signal.Notify(signalChan, os.Interrupt, syscall.SIGTERM)
 
 	go func() {
-		<-signalChan
-		// Stop the log socket server before exiting
-		logsocket.StopServer()
-		close(stopChan)
+		for range signalChan {
+			stop()
+		}
 	}()
 
 	for _, pkg := range selectedPackages {
 		log.Printf("Selected package: %s\n", pkg.PackageJson.Name)
-		wg.Add(1)
-		go watchForChanges(&wg, stopChan, pkg, projectPath+"/webapp", !c.Bool("no-build"))
+		session.attach(pkg)
+	}
+	session.wg.Add(1)
+	go session.watchRootConfig(projectPath)
+	// The web viewer, editor tasks and 'mtcli watch add' control the session through the log socket server
+	logsocket.SetController(session)
+
+	if dash != nil {
+		go dash.run(session)
 	}
 
-	wg.Wait() // Wait for all goroutines to finish
+	// Packages can be added until the session is stopped
+	session.wait()
+
+	// Builds have exited, so nothing logs to the dashboard or the viewer anymore
+	if dash != nil {
+		dash.close()
+	}
+	log.SetOutput(originalLogger)
+	logsocket.StopServer()
+	if summary := session.summary(); len(summary) > 0 {
+		printBuildSummary(summary)
+	}
 	return nil
 }
 
-// addDirsToWatcher recursively adds directories to the watcher, skipping node_modules
-func addDirsToWatcher(watcher *fsnotify.Watcher, rootPath string) error {
-	return filepath.Walk(rootPath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		unallowedDirs := []string{"node_modules", ".git", "dist", "build", "test", "tests", "features"}
-		if info.IsDir() {
-			for _, dir := range unallowedDirs {
-				if filepath.Base(path) == dir {
-					return filepath.SkipDir
-				}
-			}
-		}
-		if info.IsDir() {
-			err = watcher.Add(path)
-			if err != nil {
-				log.Fatal("Failed to add path to watcher: ", err)
-			}
-		}
-		return nil
-	})
+// logServerAddress returns the preferred port and bind address of the log
+// server, the flags overriding the server settings of the root .mtcli.yaml
+func logServerAddress(c *cli.Context, config helpers.ServerConfig) (int, string) {
+	port, bind := logsocket.DefaultPort, logsocket.DefaultBind
+	if config.Port != 0 {
+		port = config.Port
+	}
+	if config.Bind != "" {
+		bind = config.Bind
+	}
+	if c.IsSet("port") {
+		port = c.Int("port")
+	}
+	if c.IsSet("bind") {
+		bind = c.String("bind")
+	}
+	return port, bind
 }
 
-func watchForChanges(wg *sync.WaitGroup, stopChan <-chan struct{}, pkg helpers.NodePackage, webappPath string, initialBuild bool) {
-	defer wg.Done()
+// watchPhase is what the watch loop of a package is waiting for
+type watchPhase int
+
+const (
+	phaseIdle           watchPhase = iota // Waiting for changes
+	phaseDebouncing                       // Waiting for the changes to settle before building
+	phaseBuilding                         // A build is queued or running
+	phaseRebuildPending                   // The running build was cancelled, a new one starts once it exits
+)
+
+// debounceTimeout is how long changes have to settle before a build starts
+const debounceTimeout = 1000 * time.Millisecond
 
-	// Create a package-specific logger
-	packageName := pkg.PackageJson.Name
-	packageLogger := log.New(logsocket.NewLogWriter(os.Stdout, packageName), "", log.LstdFlags)
+// watchForChanges watches and builds a package until the session is stopped or
+// the package is removed from it. It is the only goroutine that starts builds
+// of the package, so there is never more than one. When the name in the
+// package.json changed, it stops and returns the package under its new name.
+func watchForChanges(session *watchSession, watched *watchedPackage) *helpers.NodePackage {
+	pkg := watched.pkg
+	packageLogger := watched.logger
 
-	watcher, err := fsnotify.NewWatcher()
+	ignore, err := helpers.NewIgnoreMatcher(pkg)
 	if err != nil {
-		packageLogger.Fatalf("Failed to create watcher: %v", err)
+		packageLogger.Printf("Not watching %s, failed to read ignore rules: %v", pkg.PackageJson.Name, err)
+		return nil
 	}
-	defer watcher.Close()
-	packageLogger.Printf("Watching for changes in package: %s", pkg.Path)
-
-	if err := addDirsToWatcher(watcher, pkg.Path); err != nil {
-		packageLogger.Fatalf("Failed to walk through directories: %v", err)
+	events, err := session.watchPackage(watched, ignore)
+	if err != nil {
+		packageLogger.Printf("Not watching %s: %v", pkg.PackageJson.Name, err)
+		return nil
 	}
+	defer session.unwatchPackage(watched)
+	packageLogger.Printf("Watching for changes in package: %s", pkg.Path)
 
-	buildChan := make(chan struct{}, 1)
+	// Cancelling ctx stops the running build when watching stops
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	// Add debounce timer
-	var debounceTimer *time.Timer
-	debounceTimeout := 1000 * time.Millisecond // Configurable debounce delay
+	phase := phaseIdle
+	var debounce <-chan time.Time                   // Fires once changes settled, nil when not debouncing
+	reload := false                                 // The settling changes may change how the package is built
+	var cancelBuild context.CancelFunc              // Cancels the current build
+	buildDone := make(chan *helpers.BuildResult, 1) // Receives the result of the current build
 
-	go handleBuilds(ctx, buildChan, pkg, webappPath, packageLogger)
+	startBuild := func() {
+		var buildCtx context.Context
+		buildCtx, cancelBuild = context.WithCancel(ctx)
+		phase = phaseBuilding
+		go runBuild(buildCtx, cancelBuild, session, watched, pkg, buildDone)
+	}
+	// reloadPackage detects the strategy and build config again and watches
+	// with the new ignore rules. Returns the package if its name changed.
+	reloadPackage := func() *helpers.NodePackage {
+		reloaded, err := helpers.ReloadNodePackage(pkg)
+		if err != nil {
+			packageLogger.Printf("Failed to reload %s, building it as before: %v", pkg.PackageJson.Name, err)
+			return nil
+		}
+		if changes := helpers.DescribePackageChanges(pkg, reloaded); len(changes) > 0 {
+			packageLogger.Printf("Package %s changed: %s", pkg.PackageJson.Name, strings.Join(changes, ", "))
+		}
+		switch {
+		case reloaded.Strategy == helpers.UNKNOWN:
+			packageLogger.Printf("%s no longer matches a build strategy, building it as before", pkg.PackageJson.Name)
+			return nil
+		case reloaded.PackageJson.Name != pkg.PackageJson.Name:
+			if session.rename(watched, reloaded) {
+				packageLogger.Printf("Watching %s as %s from now on", pkg.PackageJson.Name, reloaded.PackageJson.Name)
+				return &reloaded
+			}
+			packageLogger.Printf("Another watched package is called %s, keeping the name %s", reloaded.PackageJson.Name, pkg.PackageJson.Name)
+			return nil
+		}
 
-	// Trigger initial build if enabled
-	if initialBuild {
-		buildChan <- struct{}{}
+		ignore, err := helpers.NewIgnoreMatcher(reloaded)
+		if err != nil {
+			packageLogger.Printf("Failed to read the ignore rules of %s, building it as before: %v", pkg.PackageJson.Name, err)
+			return nil
+		}
+		session.updatePackage(watched, reloaded)
+		pkg = reloaded
+		session.unwatchPackage(watched)
+		if events, err = session.watchPackage(watched, ignore); err != nil {
+			packageLogger.Printf("Not watching %s: %v", pkg.PackageJson.Name, err)
+		}
+		return nil
+	}
+	// rebuild builds the latest changes, restarting the running build
+	rebuild := func() {
+		switch phase {
+		case phaseIdle, phaseDebouncing:
+			debounce = nil
+			startBuild()
+		case phaseBuilding:
+			// A build that has not started yet will see the changes
+			if watched.state().Status == statusQueued {
+				return
+			}
+			packageLogger.Printf("Restarting the build of %s for the new changes", pkg.PackageJson.Name)
+			cancelBuild()
+			phase = phaseRebuildPending
+		}
+	}
+	// buildChanges builds the latest changes, or remembers them while the package is paused
+	buildChanges := func() {
+		if watched.state().Paused {
+			watched.triggerBuild()
+			packageLogger.Printf("Watching %s is paused, the change will be built when it is resumed", pkg.PackageJson.Name)
+			if phase == phaseDebouncing {
+				phase = phaseIdle
+			}
+			return
+		}
+		rebuild()
+	}
+	if session.initialBuild {
+		startBuild()
 	}
 
 	for {
 		select {
-		case <-stopChan:
+		case <-session.stopChan:
+			if phase == phaseBuilding || phase == phaseRebuildPending {
+				// A build that has not started yet is dropped either way
+				if session.finishBuilds && phase == phaseBuilding && watched.state().Status == statusBuilding {
+					packageLogger.Printf("Waiting for the build of %s to finish", pkg.PackageJson.Name)
+				} else {
+					cancelBuild()
+				}
+				<-buildDone
+			}
 			packageLogger.Printf("Stopping watcher for package: %s", pkg.PackageJson.Name)
-			return
-		case event, ok := <-watcher.Events:
-			if !ok {
-				return
+			return nil
+		case <-watched.detached:
+			if phase == phaseBuilding || phase == phaseRebuildPending {
+				// A build still waiting for a free slot is dropped instead of waiting for one
+				cancelBuild()
+				session.scheduler.Drop(pkg.PackageJson.Name)
+				<-buildDone
 			}
-			handleEvent(event, buildChan, &ctx, &cancel, pkg, webappPath, &debounceTimer, debounceTimeout, packageLogger)
-		case err, ok := <-watcher.Errors:
-			if !ok {
-				return
+			packageLogger.Printf("Stopped watching %s", pkg.PackageJson.Name)
+			return nil
+		case event := <-events:
+			logEvent(event, packageLogger)
+			if helpers.IsMetadataChange(pkg, event) {
+				reload = true
+			}
+			// Every change restarts the wait, so a burst of changes causes one build
+			debounce = time.After(debounceTimeout)
+			if phase == phaseIdle {
+				phase = phaseDebouncing
+			}
+		case <-debounce:
+			debounce = nil
+			if reload {
+				reload = false
+				if renamed := reloadPackage(); renamed != nil {
+					return renamed
+				}
+			}
+			buildChanges()
+		case <-watched.reloadChan:
+			// The root .mtcli.yaml changed, only a changed package is built again
+			previous := pkg
+			if renamed := reloadPackage(); renamed != nil {
+				return renamed
+			}
+			if debounce == nil && len(helpers.DescribePackageChanges(previous, pkg)) > 0 {
+				buildChanges()
+			}
+		case <-watched.buildChan:
+			rebuild()
+		case result := <-buildDone:
+			cancelBuild()
+			if phase == phaseRebuildPending {
+				startBuild()
+				continue
+			}
+			phase = phaseIdle
+			if debounce != nil {
+				phase = phaseDebouncing
+			}
+			// Nothing changed for the dependents if the build was skipped
+			if result != nil && result.Success && !result.Cached {
+				session.cascadeBuild(pkg.PackageJson.Name)
 			}
-			packageLogger.Printf("Watcher error: %v", err)
 		}
 	}
 }
 
-func handleEvent(event fsnotify.Event, buildChan chan struct{}, ctx *context.Context,
-	cancel *context.CancelFunc, pkg helpers.NodePackage, webappPath string,
-	debounceTimer **time.Timer, debounceTimeout time.Duration, logger *log.Logger) {
-	if event.Op&fsnotify.Write == fsnotify.Write {
-		logger.Printf("File %s has been modified", event.Name)
-		// If there's an existing timer, stop it
-		if *debounceTimer != nil {
-			(*debounceTimer).Stop()
-		}
-
-		// Create a new timer
-		*debounceTimer = time.AfterFunc(debounceTimeout, func() {
-			select {
-			case buildChan <- struct{}{}:
-			default:
-				// If we can't send to buildChan, reset the build context
-				(*cancel)()
-				*ctx, *cancel = context.WithCancel(context.Background())
-				go handleBuilds(*ctx, buildChan, pkg, webappPath, logger)
-			}
-		})
+// logEvent logs a change to a watched package
+func logEvent(event helpers.WatchEvent, logger *log.Logger) {
+	// Editors that save through a temporary file and a rename, and branch
+	// switches, show up as creates, renames and removes rather than writes
+	switch {
+	case event.Has(helpers.WatchCreate) && event.IsDir:
+		logger.Printf("Directory %s has been created", event.Path)
+	case event.Has(helpers.WatchCreate):
+		logger.Printf("File %s has been created", event.Path)
+	case event.Has(helpers.WatchRemove), event.Has(helpers.WatchRename):
+		logger.Printf("%s has been removed or renamed", event.Path)
+	default:
+		logger.Printf("File %s has been modified", event.Path)
 	}
 }
 
-func handleBuilds(ctx context.Context, buildChan <-chan struct{}, pkg helpers.NodePackage, webappPath string, logger *log.Logger) {
-	for range buildChan {
+// runBuild builds the package once the scheduler lets it and sends the
+// result to done. The result is nil if ctx was cancelled before the build started.
+func runBuild(ctx context.Context, cancel context.CancelFunc, session *watchSession, watched *watchedPackage, pkg helpers.NodePackage, done chan<- *helpers.BuildResult) {
+	logger := watched.logger
+
+	var result *helpers.BuildResult
+	watched.setQueued()
+	finished, _ := session.scheduler.Submit(pkg.PackageJson.Name, func() {
+		if ctx.Err() != nil {
+			return
+		}
 		logger.Printf("Starting build for package: %s", pkg.PackageJson.Name)
-		err := helpers.BuildPackageWithLogger(ctx, pkg, webappPath, logger)
-		if err != nil {
+		watched.startBuild(cancel)
+		var err error
+		result, err = helpers.BuildPackageWithLogger(ctx, pkg, session.webappPath, logger)
+		switch {
+		case err == nil:
+		case result != nil && result.Cancelled:
+			logger.Printf("Build of %s was cancelled", pkg.PackageJson.Name)
+		default:
 			logger.Printf("Build failed: %v", err)
 		}
+	})
+	<-finished
+	watched.finishBuild(result)
+	done <- result
+}
+
+// pollFlag is the value of --poll, which can be given with or without an interval
+type pollFlag struct {
+	enabled  bool
+	interval time.Duration
+}
+
+// Set implements flag.Value
+func (f *pollFlag) Set(value string) error {
+	switch value {
+	case "true":
+		f.enabled = true
+	case "false":
+		f.enabled = false
+	default:
+		interval, err := time.ParseDuration(value)
+		if err != nil || interval <= 0 {
+			return fmt.Errorf("invalid poll interval %q, use a duration like 500ms or 2s", value)
+		}
+		f.enabled = true
+		f.interval = interval
+	}
+	return nil
+}
+
+// String implements flag.Value
+func (f *pollFlag) String() string {
+	if f == nil || !f.enabled {
+		return ""
+	}
+	if f.interval == 0 {
+		return helpers.DefaultPollInterval.String()
 	}
+	return f.interval.String()
+}
+
+// IsBoolFlag lets --poll be given without a value
+func (f *pollFlag) IsBoolFlag() bool {
+	return true
 }
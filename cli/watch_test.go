@@ -0,0 +1,497 @@
+//go:build !windows
+
+package cli
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/LajnaLegenden/transpiler4/helpers"
+)
+
+// fakeWatcher lets a test send the change events of a package
+type fakeWatcher struct {
+	mu       sync.Mutex
+	channels map[string]chan helpers.WatchEvent
+}
+
+func (w *fakeWatcher) Add(path string, ignore *helpers.IgnoreMatcher) (<-chan helpers.WatchEvent, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	events := make(chan helpers.WatchEvent, 16)
+	w.channels[path] = events
+	return events, nil
+}
+
+func (w *fakeWatcher) Remove(path string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.channels, path)
+}
+
+func (w *fakeWatcher) Close() error {
+	return nil
+}
+
+// change sends a write to file in the package folder path
+func (w *fakeWatcher) change(t *testing.T, path string, file string) {
+	w.mu.Lock()
+	events, ok := w.channels[path]
+	w.mu.Unlock()
+	if !ok {
+		t.Fatalf("%s is not watched", path)
+	}
+	events <- helpers.WatchEvent{Path: filepath.Join(path, file), Op: helpers.WatchWrite}
+}
+
+// syncBuffer collects the log of the watched packages
+type syncBuffer struct {
+	mu     sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buffer.Write(p)
+}
+
+func (b *syncBuffer) count(text string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return strings.Count(b.buffer.String(), text)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buffer.String()
+}
+
+// newTestSession returns a session that can build packages, with the log of
+// its packages in logs. Dependents are rebuilt after their dependencies.
+func newTestSession(t *testing.T, watcher helpers.PackageWatcher, logs io.Writer, packages ...helpers.NodePackage) (*watchSession, chan struct{}) {
+	helpers.SetBuildCacheEnabled(false)
+	t.Cleanup(func() { helpers.SetBuildCacheEnabled(true) })
+	// Keeps build notifications out of the test output
+	originalOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(originalOutput) })
+
+	discard := log.New(io.Discard, "", 0)
+	poller := helpers.NewPollWatcher(time.Second, discard)
+	t.Cleanup(func() { poller.Close() })
+	stopChan := make(chan struct{})
+	session := &watchSession{
+		watcher:      watcher,
+		poller:       poller,
+		scheduler:    helpers.NewScheduler(1, discard),
+		rootPath:     packages[0].RootPath,
+		buildable:    packages,
+		buildGraph:   helpers.NewDependencyGraph(packages),
+		webappPath:   filepath.Join(packages[0].RootPath, "webapp"),
+		cascade:      true,
+		initialBuild: true,
+		newLogger: func(name string) *log.Logger {
+			return log.New(logs, "", 0)
+		},
+		stop:     func() {},
+		stopChan: stopChan,
+		packages: make(map[string]*watchedPackage),
+	}
+	return session, stopChan
+}
+
+// newTestPackage returns the package name in rootDir that runs command to
+// build. Every build is recorded in runs-<name>.log of rootDir, whose path is
+// returned as well.
+func newTestPackage(t *testing.T, rootDir string, name string, command string, dependencies ...string) (helpers.NodePackage, string) {
+	packageDir := filepath.Join(rootDir, "packages", name)
+	if err := os.MkdirAll(packageDir, 0755); err != nil {
+		t.Fatalf("Failed to create package directory: %v", err)
+	}
+	packageName := "@mediatool/" + name
+	if err := os.WriteFile(filepath.Join(packageDir, "package.json"), []byte(`{"name": "`+packageName+`"}`), 0644); err != nil {
+		t.Fatalf("Failed to write package.json: %v", err)
+	}
+	packageJson := &helpers.PackageJson{Name: packageName, Dependencies: make(map[string]string)}
+	for _, dependency := range dependencies {
+		packageJson.Dependencies["@mediatool/"+dependency] = "workspace:*"
+	}
+	runsPath := filepath.Join(rootDir, "runs-"+name+".log")
+	return helpers.NodePackage{
+		Path:        packageDir,
+		RootPath:    rootDir,
+		PackageJson: packageJson,
+		Strategy:    helpers.TRANSPILED,
+		Config: &helpers.BuildConfig{
+			Commands: []string{"echo run >> " + runsPath + " && " + command},
+			Outputs:  []string{},
+		},
+	}, runsPath
+}
+
+// newSlowPackage returns a package whose build takes two seconds, and the
+// file its builds are recorded in
+func newSlowPackage(t *testing.T) (helpers.NodePackage, string) {
+	return newTestPackage(t, t.TempDir(), "slow", "sleep 2")
+}
+
+// waitFor fails the test if condition doesn't become true within timeout
+func waitFor(t *testing.T, timeout time.Duration, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func countRuns(runsPath string) int {
+	data, _ := os.ReadFile(runsPath)
+	return strings.Count(string(data), "run\n")
+}
+
+func TestWatchRestartsBuildForChanges(t *testing.T) {
+	pkg, runsPath := newSlowPackage(t)
+	watcher := &fakeWatcher{channels: make(map[string]chan helpers.WatchEvent)}
+	logs := &syncBuffer{}
+	session, stopChan := newTestSession(t, watcher, logs, pkg)
+
+	if !session.attach(pkg) {
+		t.Fatalf("Expected the package to be attached")
+	}
+	watched, err := session.lookup("@mediatool/slow")
+	if err != nil {
+		t.Fatalf("Expected the package to be watched: %v", err)
+	}
+	waitFor(t, 5*time.Second, "the initial build", func() bool {
+		return watched.state().Status == statusBuilding
+	})
+
+	// A burst of changes during the build
+	for _, file := range []string{"index.ts", "ui.ts", "index.ts"} {
+		watcher.change(t, pkg.Path, file)
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	waitFor(t, 10*time.Second, "the build of the changes", func() bool {
+		state := watched.state()
+		return logs.count("Starting build for package") == 2 && state.Status == statusIdle && state.LastResult != nil
+	})
+	// Nothing else is queued once the changes settled
+	time.Sleep(debounceTimeout + 500*time.Millisecond)
+
+	if cancelled := logs.count("was cancelled"); cancelled != 1 {
+		t.Errorf("Expected the running build to be cancelled once, got %d times:\n%s", cancelled, logs)
+	}
+	if started := logs.count("Starting build for package"); started != 2 {
+		t.Errorf("Expected exactly one build after the initial one, got %d builds:\n%s", started, logs)
+	}
+	if runs := countRuns(runsPath); runs != 2 {
+		t.Errorf("Expected the build command to run twice, got %d", runs)
+	}
+	if result := watched.state().LastResult; !result.Success {
+		t.Errorf("Expected the build of the changes to succeed, got: %+v", result)
+	}
+
+	close(stopChan)
+	session.wait()
+}
+
+func TestWatchSessionRemoveWaitsForBuild(t *testing.T) {
+	pkg, runsPath := newSlowPackage(t)
+	watcher := &fakeWatcher{channels: make(map[string]chan helpers.WatchEvent)}
+	logs := &syncBuffer{}
+	session, stopChan := newTestSession(t, watcher, logs, pkg)
+
+	if added, err := session.Add("slow"); err != nil || len(added) != 1 {
+		t.Fatalf("Expected slow to be added, got %v: %v", added, err)
+	}
+	watched, err := session.lookup("@mediatool/slow")
+	if err != nil {
+		t.Fatalf("Expected the package to be watched: %v", err)
+	}
+	waitFor(t, 5*time.Second, "the initial build", func() bool {
+		return watched.state().Status == statusBuilding
+	})
+
+	// Rebuilding while building restarts the build
+	if err := session.Rebuild("@mediatool/slow"); err != nil {
+		t.Fatalf("Expected no error from Rebuild, got: %v", err)
+	}
+	waitFor(t, 5*time.Second, "the restarted build", func() bool {
+		return logs.count("Starting build for package") == 2 && watched.state().Status == statusBuilding
+	})
+
+	removed, err := session.Remove("@mediatool/slow")
+	if err != nil || len(removed) != 1 {
+		t.Fatalf("Expected slow to be removed, got %v: %v", removed, err)
+	}
+	// The build exited before Remove returned
+	if cancelled := logs.count("was cancelled"); cancelled != 2 {
+		t.Errorf("Expected both builds to be cancelled by the time Remove returned, got %d:\n%s", cancelled, logs)
+	}
+	if status := watched.state().Status; status == statusBuilding || status == statusQueued {
+		t.Errorf("Expected the removed package to have stopped building, got %s", status)
+	}
+	if _, err := session.lookup("@mediatool/slow"); err == nil {
+		t.Errorf("Expected the package to be gone from the session")
+	}
+	if available := session.Available(); len(available) != 1 {
+		t.Errorf("Expected the removed package to be available again, got %d packages", len(available))
+	}
+	if runs := countRuns(runsPath); runs != 2 {
+		t.Errorf("Expected two builds to have started, got %d", runs)
+	}
+
+	close(stopChan)
+	session.wait()
+}
+
+// attachAll starts watching packages, without building them until they change
+func attachAll(t *testing.T, session *watchSession, packages ...helpers.NodePackage) {
+	session.initialBuild = false
+	for _, pkg := range packages {
+		if !session.attach(pkg) {
+			t.Fatalf("Expected %s to be attached", pkg.PackageJson.Name)
+		}
+		watcher := session.watcher.(*fakeWatcher)
+		waitFor(t, 5*time.Second, "watching "+pkg.PackageJson.Name, func() bool {
+			watcher.mu.Lock()
+			defer watcher.mu.Unlock()
+			return watcher.channels[pkg.Path] != nil
+		})
+	}
+}
+
+// waitForBuild waits until the package called name finished a build
+func waitForBuild(t *testing.T, session *watchSession, name string) *helpers.BuildResult {
+	t.Helper()
+	watched, err := session.lookup(name)
+	if err != nil {
+		t.Fatalf("Expected %s to be watched: %v", name, err)
+	}
+	var result *helpers.BuildResult
+	waitFor(t, 10*time.Second, "the build of "+name, func() bool {
+		state := watched.state()
+		result = state.LastResult
+		return (state.Status == statusIdle || state.Status == statusFailed) && result != nil
+	})
+	return result
+}
+
+func TestWatchCascadeBuild(t *testing.T) {
+	rootDir := t.TempDir()
+	lib, libRuns := newTestPackage(t, rootDir, "lib", "true")
+	direct, directRuns := newTestPackage(t, rootDir, "direct", "true", "lib")
+	// Not watched, app still depends on lib through it
+	mid, midRuns := newTestPackage(t, rootDir, "mid", "true", "lib")
+	app, appRuns := newTestPackage(t, rootDir, "app", "true", "mid")
+	other, otherRuns := newTestPackage(t, rootDir, "other", "true")
+
+	watcher := &fakeWatcher{channels: make(map[string]chan helpers.WatchEvent)}
+	logs := &syncBuffer{}
+	session, stopChan := newTestSession(t, watcher, logs, lib, direct, mid, app, other)
+	attachAll(t, session, lib, direct, app, other)
+
+	watcher.change(t, lib.Path, "index.ts")
+	waitForBuild(t, session, "@mediatool/lib")
+	waitFor(t, 10*time.Second, "the dependents to be rebuilt", func() bool {
+		return countRuns(directRuns) == 1 && countRuns(appRuns) == 1
+	})
+	waitForBuild(t, session, "@mediatool/direct")
+	waitForBuild(t, session, "@mediatool/app")
+	// Nothing else is queued by the rebuilt dependents
+	time.Sleep(debounceTimeout)
+
+	expected := map[string]struct {
+		runsPath string
+		runs     int
+	}{
+		"lib":    {libRuns, 1},
+		"direct": {directRuns, 1},
+		"mid":    {midRuns, 0},
+		"app":    {appRuns, 1},
+		"other":  {otherRuns, 0},
+	}
+	for name, test := range expected {
+		if runs := countRuns(test.runsPath); runs != test.runs {
+			t.Errorf("Expected %s to be built %d times, got %d:\n%s", name, test.runs, runs, logs)
+		}
+	}
+	for _, name := range []string{"direct", "app"} {
+		message := "Rebuilding @mediatool/" + name + " because its dependency @mediatool/lib was rebuilt"
+		if count := logs.count(message); count != 1 {
+			t.Errorf("Expected %q to be logged once, got %d times", message, count)
+		}
+	}
+
+	close(stopChan)
+	session.wait()
+}
+
+func TestWatchCascadeBuildSkipped(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string // Builds lib
+		outputs []string
+		cascade bool
+		builds  int // Changes to lib
+	}{
+		{"failed build", "exit 1", nil, true, 1},
+		// The second build of lib is cached
+		{"cached build", "mkdir -p dist && echo built > dist/index.js", []string{"dist"}, true, 2},
+		{"cascade turned off", "true", nil, false, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rootDir := t.TempDir()
+			lib, _ := newTestPackage(t, rootDir, "lib", test.command)
+			if test.outputs != nil {
+				lib.Config.Outputs = test.outputs
+			}
+			app, appRuns := newTestPackage(t, rootDir, "app", "true", "lib")
+
+			watcher := &fakeWatcher{channels: make(map[string]chan helpers.WatchEvent)}
+			logs := &syncBuffer{}
+			session, stopChan := newTestSession(t, watcher, logs, lib, app)
+			session.cascade = test.cascade
+			helpers.SetBuildCacheEnabled(true)
+			attachAll(t, session, lib, app)
+
+			var result *helpers.BuildResult
+			for i := 0; i < test.builds; i++ {
+				if i > 0 {
+					// The successful first build cascades
+					waitFor(t, 10*time.Second, "the first rebuild of app", func() bool {
+						return countRuns(appRuns) == 1
+					})
+					waitForBuild(t, session, "@mediatool/app")
+				}
+				watcher.change(t, lib.Path, "index.ts")
+				waitFor(t, 10*time.Second, "the build of the change", func() bool {
+					return logs.count("Starting build for package: @mediatool/lib") == i+1
+				})
+				result = waitForBuild(t, session, "@mediatool/lib")
+			}
+			if test.builds > 1 && !result.Cached {
+				t.Fatalf("Expected the last build of lib to be cached, got: %+v", result)
+			}
+			time.Sleep(500 * time.Millisecond)
+
+			if runs := countRuns(appRuns); runs != test.builds-1 {
+				t.Errorf("Expected app to be rebuilt %d times, got %d:\n%s", test.builds-1, runs, logs)
+			}
+			if count := logs.count("because its dependency"); count != test.builds-1 {
+				t.Errorf("Expected %d cascaded builds to be logged, got %d:\n%s", test.builds-1, count, logs)
+			}
+
+			close(stopChan)
+			session.wait()
+		})
+	}
+}
+
+func TestWatchReloadsForRootConfig(t *testing.T) {
+	rootDir := t.TempDir()
+	runsPath := filepath.Join(rootDir, "runs.log")
+	writeRootConfig := func(command string) {
+		config := "packages:\n  \"@mediatool/lib\":\n    commands: [\"echo " + command + " >> " + runsPath + "\"]\n    outputs: []\n"
+		if err := os.WriteFile(filepath.Join(rootDir, helpers.ConfigFileName), []byte(config), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", helpers.ConfigFileName, err)
+		}
+	}
+	writeRootConfig("one")
+	var packages []helpers.NodePackage
+	for _, name := range []string{"lib", "other"} {
+		packageDir := filepath.Join(rootDir, "packages", name)
+		if err := os.MkdirAll(packageDir, 0755); err != nil {
+			t.Fatalf("Failed to create package directory: %v", err)
+		}
+		os.WriteFile(filepath.Join(packageDir, "package.json"), []byte(`{"name": "@mediatool/`+name+`"}`), 0644)
+		os.WriteFile(filepath.Join(packageDir, "rollup.config.mjs"), []byte("export default {}"), 0644)
+		pkg, err := helpers.ReloadNodePackage(helpers.NodePackage{Path: packageDir, RootPath: rootDir})
+		if err != nil || pkg.Strategy == helpers.UNKNOWN {
+			t.Fatalf("Expected %s to be buildable, got %s: %v", name, pkg.Strategy, err)
+		}
+		packages = append(packages, pkg)
+	}
+
+	watcher := &fakeWatcher{channels: make(map[string]chan helpers.WatchEvent)}
+	logs := &syncBuffer{}
+	session, stopChan := newTestSession(t, watcher, logs, packages...)
+	attachAll(t, session, packages...)
+	session.wg.Add(1)
+	go session.watchRootConfig(rootDir)
+	// Lets the first look at the config happen before it changes
+	time.Sleep(200 * time.Millisecond)
+
+	writeRootConfig("two")
+	waitFor(t, 10*time.Second, "the build with the new commands", func() bool {
+		data, _ := os.ReadFile(runsPath)
+		return string(data) == "two\n"
+	})
+	waitForBuild(t, session, "@mediatool/lib")
+	if count := logs.count("Package @mediatool/lib changed: build commands changed"); count != 1 {
+		t.Errorf("Expected the changed commands to be logged once, got %d times:\n%s", count, logs)
+	}
+	// The settings of other did not change
+	if count := logs.count("Starting build for package: @mediatool/other"); count != 0 {
+		t.Errorf("Expected other not to be built, got %d builds:\n%s", count, logs)
+	}
+
+	close(stopChan)
+	session.wait()
+}
+
+func TestWatchSessionAddSkipsOtherSessions(t *testing.T) {
+	pkg, runsPath := newSlowPackage(t)
+	watcher := &fakeWatcher{channels: make(map[string]chan helpers.WatchEvent)}
+	logs := &syncBuffer{}
+	session, stopChan := newTestSession(t, watcher, logs, pkg)
+
+	// A session started with --if-running others watches slow
+	other := exec.Command("sleep", "30")
+	if err := other.Start(); err != nil {
+		t.Fatalf("Failed to start a process: %v", err)
+	}
+	defer other.Process.Kill()
+	lockPath := filepath.Join(pkg.RootPath, helpers.StateDirName, "session-"+strconv.Itoa(other.Process.Pid)+".lock")
+	os.MkdirAll(filepath.Dir(lockPath), 0755)
+	if err := os.WriteFile(lockPath, []byte(`{"pid": `+strconv.Itoa(other.Process.Pid)+`, "packages": ["@mediatool/slow"]}`), 0644); err != nil {
+		t.Fatalf("Failed to write the lock: %v", err)
+	}
+
+	added, err := session.Add("slow")
+	if err == nil || !strings.Contains(err.Error(), "watched by another session") {
+		t.Errorf("Expected slow to be refused, got %v: %v", added, err)
+	}
+	if _, err := session.lookup("@mediatool/slow"); err == nil {
+		t.Errorf("Expected slow not to be watched")
+	}
+
+	// Once the other session stopped, slow can be added
+	os.Remove(lockPath)
+	if added, err := session.Add("slow"); err != nil || len(added) != 1 {
+		t.Errorf("Expected slow to be added, got %v: %v", added, err)
+	}
+	session.Remove("@mediatool/slow")
+	if runs := countRuns(runsPath); runs > 1 {
+		t.Errorf("Expected at most one build, got %d", runs)
+	}
+
+	close(stopChan)
+	session.wait()
+}
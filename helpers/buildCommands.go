@@ -2,140 +2,264 @@ package helpers
 
 import (
 	"context"
+	"fmt"
+	"io"
 	"log"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/gen2brain/beeep"
 )
 
 func RunCommand(ctx context.Context, command string, path string) error {
-	//dry run
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	default:
-		cmd := exec.Command("sh", "-c", command)
-		cmd.Dir = path
-		cmd.Stdout = log.Writer()
-		cmd.Stderr = log.Writer()
-
-		err := cmd.Run()
-		if err != nil {
-			if err.Error() != "context: canceled" {
-				beeep.Notify("Running command failed", err.Error(), "")
-				return err
-			}
-		}
-		return nil
-	}
+	_, err := runCommand(ctx, command, path, nil, log.Writer())
+	return err
 }
 
 // RunCommandWithLogger runs a command with a custom logger
 func RunCommandWithLogger(ctx context.Context, command string, path string, logger *log.Logger) error {
+	_, err := runCommand(ctx, command, path, nil, logger.Writer())
+	return err
+}
+
+// CommandGracePeriod is how long a cancelled command gets to exit before it is killed
+var CommandGracePeriod = 5 * time.Second
+
+// runningCommands are the commands started by runCommand that have not exited yet
+var (
+	runningCommands    = make(map[*exec.Cmd]bool)
+	runningCommandsMux sync.Mutex
+)
+
+// KillRunningCommands kills every running build command along with the
+// processes it started. It is meant for exiting right away, when there is no
+// time to cancel the builds and wait for them.
+func KillRunningCommands() {
+	runningCommandsMux.Lock()
+	defer runningCommandsMux.Unlock()
+	for cmd := range runningCommands {
+		killProcessTree(cmd)
+	}
+}
+
+// runCommand runs a shell command in path with env added to the current environment.
+// When ctx is cancelled the command and every process it started are stopped.
+func runCommand(ctx context.Context, command string, path string, env map[string]string, output io.Writer) (StepResult, error) {
+	step := StepResult{Command: command, ExitCode: -1}
+	startTime := time.Now()
+	fail := func(err error) (StepResult, error) {
+		step.DurationMs = time.Since(startTime).Milliseconds()
+		step.Error = err.Error()
+		return step, err
+	}
+
 	//dry run
 	select {
 	case <-ctx.Done():
-		return ctx.Err()
+		return fail(ctx.Err())
 	default:
-		cmd := exec.Command("sh", "-c", command)
-		cmd.Dir = path
-		cmd.Stdout = logger.Writer()
-		cmd.Stderr = logger.Writer()
+	}
 
-		err := cmd.Run()
-		if err != nil {
-			if err.Error() != "context: canceled" {
-				beeep.Notify("Running command failed", err.Error(), "")
-				return err
-			}
+	stderrTail := newTailWriter(stderrTailLines)
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Dir = path
+	cmd.Stdout = output
+	cmd.Stderr = io.MultiWriter(output, stderrTail)
+	// Don't wait forever on output pipes held open by processes that left the group
+	cmd.WaitDelay = CommandGracePeriod
+	setProcessGroup(cmd)
+	if len(env) > 0 {
+		cmd.Env = os.Environ()
+		for key, value := range env {
+			cmd.Env = append(cmd.Env, key+"="+value)
 		}
-		return nil
 	}
-}
 
-func GetBuildCommand(pkg NodePackage, webappPath string) []string {
-	if pkg.Strategy == "TRANSPILED_YARN" {
-		return []string{
-			"yarn transpile",
-			"rm -rf " + webappPath + "/node_modules/" + pkg.PackageJson.Name + "/dist",
-			"cp -R " + pkg.Path + "/dist " + webappPath + "/node_modules/" + pkg.PackageJson.Name,
+	if err := cmd.Start(); err != nil {
+		beeep.Notify("Running command failed", err.Error(), "")
+		return fail(err)
+	}
+	runningCommandsMux.Lock()
+	runningCommands[cmd] = true
+	runningCommandsMux.Unlock()
+	defer func() {
+		runningCommandsMux.Lock()
+		delete(runningCommands, cmd)
+		runningCommandsMux.Unlock()
+	}()
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+		if cmd.ProcessState != nil {
+			step.ExitCode = cmd.ProcessState.ExitCode()
 		}
+	case <-ctx.Done():
+		stopProcessTree(cmd, done)
+		err = ctx.Err()
 	}
-	if pkg.Strategy == "TRANSPILED" {
-		return []string{
-			"pnpm transpile",
-			"rm -rf " + webappPath + "/node_modules/" + pkg.PackageJson.Name + "/dist",
-			"cp -R " + pkg.Path + "/dist " + webappPath + "/node_modules/" + pkg.PackageJson.Name,
+	step.StderrTail = stderrTail.Lines()
+	if err != nil {
+		if ctx.Err() == nil {
+			beeep.Notify("Running command failed", err.Error(), "")
 		}
+		return fail(err)
 	}
-	if pkg.Strategy == "AMEND_NATIVE" {
-		commands := []string{}
+	step.DurationMs = time.Since(startTime).Milliseconds()
+	return step, nil
+}
 
-		// Remove old folders first
-		commands = append(commands,
-			"rm -rf "+webappPath+"/node_modules/"+pkg.PackageJson.Name+"/lib",
-			"rm -rf "+webappPath+"/node_modules/"+pkg.PackageJson.Name+"/boundaries",
-			"rm -rf "+webappPath+"/node_modules/"+pkg.PackageJson.Name+"/amend")
+// stopProcessTree terminates the process tree of cmd and kills it if it is
+// still running after the grace period. Returns once cmd has exited.
+func stopProcessTree(cmd *exec.Cmd, done <-chan error) {
+	terminateProcessTree(cmd)
+	select {
+	case <-done:
+		// The shell exited, make sure nothing it started lingers
+		killProcessTree(cmd)
+	case <-time.After(CommandGracePeriod):
+		killProcessTree(cmd)
+		<-done
+	}
+}
+
+// GetBuildCommand returns the shell commands that build the package
+func GetBuildCommand(pkg NodePackage) []string {
+	return append([]string{}, GetBuildConfig(pkg).Commands...)
+}
+
+// SyncOutputs copies the outputs and copy targets of a package into
+// webapp/node_modules/<package name>, touching only files that changed
+func SyncOutputs(ctx context.Context, pkg NodePackage, webappPath string) ([]SyncStats, error) {
+	config := GetBuildConfig(pkg)
+	mode := SyncMode(config.Compare)
+	if mode == "" {
+		mode = SyncCompareMetadata
+	}
 
-		// Only copy folders if they exist
-		if pkg.FolderItems["lib"] {
-			commands = append(commands, "cp -R lib "+webappPath+"/node_modules/"+pkg.PackageJson.Name)
+	targetPath := filepath.Join(webappPath, "node_modules", pkg.PackageJson.Name)
+	var results []SyncStats
+	for _, target := range config.CopyTargets() {
+		stats, err := SyncDir(ctx, filepath.Join(pkg.Path, target.From), filepath.Join(targetPath, target.To), mode)
+		if err != nil {
+			return results, fmt.Errorf("failed to copy %s: %w", target.From, err)
 		}
-		if pkg.FolderItems["boundaries"] {
-			commands = append(commands, "cp -R boundaries "+webappPath+"/node_modules/"+pkg.PackageJson.Name)
+		results = append(results, stats)
+	}
+	return results, nil
+}
+
+// BuildPackage builds a package and copies its outputs into the webapp
+func BuildPackage(ctx context.Context, pkg NodePackage, webappPath string) (*BuildResult, error) {
+	return BuildPackageWithLogger(ctx, pkg, webappPath, log.Default())
+}
+
+// BuildPackageWithLogger builds a package using a custom logger. The result
+// is returned even when the build fails.
+func BuildPackageWithLogger(ctx context.Context, pkg NodePackage, webappPath string, logger *log.Logger) (*BuildResult, error) {
+	config := GetBuildConfig(pkg)
+	result := NewBuildResult(pkg)
+	if err := ctx.Err(); err != nil {
+		// Cancelled while waiting for a free build slot
+		result.finish(err)
+		return result, err
+	}
+
+	cache, hash := lookupBuildCache(pkg, logger)
+	if cache != nil && cache.Matches(pkg.PackageJson.Name, hash) && outputsExist(pkg, webappPath) {
+		logger.Printf("No changes since the last build of %s, skipping build commands", pkg.PackageJson.Name)
+		result.Cached = true
+	} else {
+		if cache != nil {
+			// The commands may leave half written outputs behind when they fail or are cancelled
+			if err := cache.Invalidate(pkg.PackageJson.Name); err != nil {
+				logger.Printf("Failed to update build cache: %v", err)
+			}
 		}
-		if pkg.FolderItems["amend"] {
-			commands = append(commands, "cp -R amend "+webappPath+"/node_modules/"+pkg.PackageJson.Name)
+		SendNotification("Build started", pkg.PackageJson.Name+" build started")
+		for _, command := range config.Commands {
+			step, err := runCommand(ctx, command, pkg.Path, config.Env, logger.Writer())
+			result.Steps = append(result.Steps, step)
+			if err != nil {
+				result.FailedStep = command
+				result.finish(err)
+				return result, err
+			}
 		}
-
-		return commands
 	}
-	if pkg.Strategy == "MAKEFILE_BUILD" {
-		return []string{
-			"make build",
+
+	artifacts, err := SyncOutputs(ctx, pkg, webappPath)
+	result.Artifacts = append(result.Artifacts, artifacts...)
+	for _, stats := range artifacts {
+		if stats.HasChanges() {
+			logger.Printf("Copied %s: %s", filepath.Base(stats.Target), stats)
+		} else {
+			logger.Printf("%s is up to date in the webapp", filepath.Base(stats.Target))
 		}
 	}
-	if pkg.Strategy == "TRANSPILED_LEGACY" {
-		return []string{
-			"pnpm prepublishOnly",
-			"rm -rf " + webappPath + "/node_modules/" + pkg.PackageJson.Name + "/dist",
-			"cp -R " + pkg.Path + "/dist " + webappPath + "/node_modules/" + pkg.PackageJson.Name,
+	if err != nil {
+		if cache != nil {
+			// The outputs may be gone, so the next build has to run the commands again
+			cache.Invalidate(pkg.PackageJson.Name)
 		}
+		result.FailedStep = "copy"
+		result.finish(err)
+		return result, err
 	}
-	return []string{}
-}
 
-func BuildPackage(ctx context.Context, pkg NodePackage, webappPath string) error {
-	commands := GetBuildCommand(pkg, webappPath)
-	//Store start time
-	startTime := time.Now()
-	SendNotification("Build started", pkg.PackageJson.Name+" build started")
-	for _, command := range commands {
-		err := RunCommand(ctx, command, pkg.Path)
-		if err != nil {
-			return err
+	if cache != nil && hash != "" {
+		if err := cache.Store(pkg.PackageJson.Name, hash); err != nil {
+			logger.Printf("Failed to update build cache: %v", err)
 		}
 	}
-	SendNotification("Build completed", pkg.PackageJson.Name+" completed in "+time.Since(startTime).String())
-	return nil
-}
 
-// BuildPackageWithLogger builds a package using a custom logger
-func BuildPackageWithLogger(ctx context.Context, pkg NodePackage, webappPath string, logger *log.Logger) error {
-	commands := GetBuildCommand(pkg, webappPath)
-	//Store start time
-	startTime := time.Now()
-	SendNotification("Build started", pkg.PackageJson.Name+" build started")
+	result.finish(nil)
+	SendNotification("Build completed", pkg.PackageJson.Name+" completed in "+result.Duration().String())
+	return result, nil
+}
 
-	for _, command := range commands {
-		err := RunCommandWithLogger(ctx, command, pkg.Path, logger)
-		if err != nil {
-			return err
+// outputsExist reports whether everything that gets copied into the webapp is
+// still there, in the package and in the webapp. A package that copies nothing
+// has no outputs to check, so its commands always run.
+func outputsExist(pkg NodePackage, webappPath string) bool {
+	targets := GetBuildConfig(pkg).CopyTargets()
+	if len(targets) == 0 {
+		return false
+	}
+	targetPath := filepath.Join(webappPath, "node_modules", pkg.PackageJson.Name)
+	for _, target := range targets {
+		if _, err := os.Stat(filepath.Join(pkg.Path, target.From)); err != nil {
+			return false
+		}
+		if _, err := os.Stat(filepath.Join(targetPath, target.To)); err != nil {
+			return false
 		}
 	}
+	return true
+}
 
-	duration := time.Since(startTime).String()
-	SendNotification("Build completed", pkg.PackageJson.Name+" completed in "+duration)
-	return nil
+// lookupBuildCache returns the build cache and the current source hash of a
+// package. The cache is nil when caching is disabled or not available.
+func lookupBuildCache(pkg NodePackage, logger *log.Logger) (*BuildCache, string) {
+	cache, err := GetBuildCache(pkg.RootPath)
+	if err != nil {
+		logger.Printf("Build cache unavailable: %v", err)
+		return nil, ""
+	}
+	if cache == nil {
+		return nil, ""
+	}
+	hash, err := HashPackage(pkg)
+	if err != nil {
+		logger.Printf("Failed to hash %s, building without cache: %v", pkg.PackageJson.Name, err)
+		return nil, ""
+	}
+	return cache, cache.WithDependencies(pkg, hash)
 }
@@ -0,0 +1,117 @@
+package helpers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// stderrTailLines is how many lines of stderr are kept for each command
+const stderrTailLines = 20
+
+// StepResult describes one command that ran as part of a build
+type StepResult struct {
+	Command    string   `json:"command"`
+	DurationMs int64    `json:"durationMs"`
+	ExitCode   int      `json:"exitCode"` // -1 when the command did not exit on its own
+	StderrTail []string `json:"stderrTail,omitempty"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// BuildResult describes the outcome of building a single package
+type BuildResult struct {
+	Package    string          `json:"package"`
+	Strategy   LinkingStrategy `json:"strategy"`
+	Success    bool            `json:"success"`
+	Cached     bool            `json:"cached"`    // Build commands were skipped because nothing changed
+	Skipped    bool            `json:"skipped"`   // The package was not built at all, see Error
+	Cancelled  bool            `json:"cancelled"` // The build was stopped before it finished
+	StartedAt  time.Time       `json:"startedAt"`
+	DurationMs int64           `json:"durationMs"`
+	Steps      []StepResult    `json:"steps"`
+	Artifacts  []SyncStats     `json:"artifacts"`
+	FailedStep string          `json:"failedStep,omitempty"`
+	Error      string          `json:"error,omitempty"`
+}
+
+// NewBuildResult creates an empty result for a package
+func NewBuildResult(pkg NodePackage) *BuildResult {
+	result := &BuildResult{
+		Strategy:  pkg.Strategy,
+		StartedAt: time.Now(),
+		Steps:     []StepResult{},
+		Artifacts: []SyncStats{},
+	}
+	if pkg.PackageJson != nil {
+		result.Package = pkg.PackageJson.Name
+	}
+	return result
+}
+
+// Duration returns how long the build took
+func (r *BuildResult) Duration() time.Duration {
+	return time.Duration(r.DurationMs) * time.Millisecond
+}
+
+// finish records the outcome and total duration of the build
+func (r *BuildResult) finish(err error) {
+	r.DurationMs = time.Since(r.StartedAt).Milliseconds()
+	r.Success = err == nil
+	if err != nil {
+		r.Error = err.Error()
+		r.Cancelled = errors.Is(err, context.Canceled)
+	}
+}
+
+// tailWriter keeps the last lines written to it
+type tailWriter struct {
+	mu      sync.Mutex
+	limit   int
+	lines   []string
+	partial []byte
+}
+
+func newTailWriter(limit int) *tailWriter {
+	return &tailWriter{limit: limit}
+}
+
+// Write implements io.Writer
+func (w *tailWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.partial = append(w.partial, p...)
+	for {
+		index := bytes.IndexByte(w.partial, '\n')
+		if index == -1 {
+			break
+		}
+		w.addLine(string(w.partial[:index]))
+		w.partial = w.partial[index+1:]
+	}
+	return len(p), nil
+}
+
+// Lines returns the kept lines, including an unfinished last line
+func (w *tailWriter) Lines() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	lines := append([]string{}, w.lines...)
+	if len(w.partial) > 0 {
+		lines = append(lines, string(w.partial))
+		if len(lines) > w.limit {
+			lines = lines[len(lines)-w.limit:]
+		}
+	}
+	return lines
+}
+
+func (w *tailWriter) addLine(line string) {
+	line = strings.TrimRight(line, "\r")
+	w.lines = append(w.lines, line)
+	if len(w.lines) > w.limit {
+		w.lines = w.lines[len(w.lines)-w.limit:]
+	}
+}
@@ -0,0 +1,230 @@
+package helpers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StateDirName is the folder under the mediatool root where mtcli keeps its state
+const StateDirName = ".mtcli"
+
+// lockfileNames are the lockfiles that are part of a package's cache key
+var lockfileNames = []string{"pnpm-lock.yaml", "yarn.lock", "package-lock.json"}
+
+// BuildCacheEntry records the last successful build of a package
+type BuildCacheEntry struct {
+	Hash    string    `json:"hash"`
+	BuiltAt time.Time `json:"builtAt"`
+}
+
+// BuildCache remembers the source hash of the last successful build of each package
+type BuildCache struct {
+	path    string
+	mu      sync.Mutex
+	Entries map[string]BuildCacheEntry `json:"entries"`
+}
+
+var (
+	buildCaches       = make(map[string]*BuildCache)
+	buildCachesMux    sync.Mutex
+	buildCacheEnabled = true
+)
+
+// SetBuildCacheEnabled turns the build cache on or off for this process
+func SetBuildCacheEnabled(enabled bool) {
+	buildCachesMux.Lock()
+	defer buildCachesMux.Unlock()
+	buildCacheEnabled = enabled
+}
+
+// GetBuildCache returns the build cache of a mediatool root, or nil when caching is disabled
+func GetBuildCache(rootPath string) (*BuildCache, error) {
+	buildCachesMux.Lock()
+	defer buildCachesMux.Unlock()
+	if !buildCacheEnabled || rootPath == "" {
+		return nil, nil
+	}
+	if cache, ok := buildCaches[rootPath]; ok {
+		return cache, nil
+	}
+	cache, err := OpenBuildCache(rootPath)
+	if err != nil {
+		return nil, err
+	}
+	buildCaches[rootPath] = cache
+	return cache, nil
+}
+
+// OpenBuildCache reads the build cache stored under the mediatool root
+func OpenBuildCache(rootPath string) (*BuildCache, error) {
+	cache := &BuildCache{
+		path:    filepath.Join(rootPath, StateDirName, "build-cache.json"),
+		Entries: make(map[string]BuildCacheEntry),
+	}
+	data, err := os.ReadFile(cache.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return cache, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, cache); err != nil {
+		// A broken cache only costs a rebuild
+		cache.Entries = make(map[string]BuildCacheEntry)
+	}
+	return cache, nil
+}
+
+// Matches reports whether the last successful build of the package had this hash
+func (c *BuildCache) Matches(packageName string, hash string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.Entries[packageName]
+	return ok && entry.Hash == hash
+}
+
+// Store records a successful build and writes the cache to disk
+func (c *BuildCache) Store(packageName string, hash string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Entries[packageName] = BuildCacheEntry{Hash: hash, BuiltAt: time.Now()}
+	return writeJSONFile(c.path, c)
+}
+
+// WithDependencies combines a package hash with the cached hashes of the
+// workspace packages it depends on, so rebuilding a dependency also
+// invalidates the packages that bundle it
+func (c *BuildCache) WithDependencies(pkg NodePackage, hash string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var dependencies []string
+	for name := range pkg.PackageJson.Dependencies {
+		if _, ok := c.Entries[name]; ok {
+			dependencies = append(dependencies, name)
+		}
+	}
+	if len(dependencies) == 0 {
+		return hash
+	}
+	sort.Strings(dependencies)
+	combined := sha256.New()
+	io.WriteString(combined, hash)
+	for _, name := range dependencies {
+		io.WriteString(combined, "\x00"+name+"\x00"+c.Entries[name].Hash)
+	}
+	return hex.EncodeToString(combined.Sum(nil))
+}
+
+// Invalidate forgets the last build of a package
+func (c *BuildCache) Invalidate(packageName string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.Entries[packageName]; !ok {
+		return nil
+	}
+	delete(c.Entries, packageName)
+	return writeJSONFile(c.path, c)
+}
+
+// HashPackage returns a hash of everything that goes into a package's build:
+// its source files, package.json, lockfiles, strategy and build config.
+// Build outputs, node_modules and .git are left out.
+func HashPackage(pkg NodePackage) (string, error) {
+	config := GetBuildConfig(pkg)
+	skip := map[string]bool{"node_modules": true, ".git": true, StateDirName: true}
+	for _, target := range config.CopyTargets() {
+		skip[strings.SplitN(filepath.ToSlash(filepath.Clean(target.From)), "/", 2)[0]] = true
+	}
+
+	hash := sha256.New()
+	// What is watched doesn't change the build
+	config.Watch = WatchConfig{}
+	configJson, err := json.Marshal(config)
+	if err != nil {
+		return "", err
+	}
+	io.WriteString(hash, string(pkg.Strategy)+"\x00")
+	hash.Write(configJson)
+
+	var files []string
+	err = filepath.Walk(pkg.Path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(pkg.Path, path)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if (path != pkg.Path && skip[rel]) || info.Name() == "node_modules" || info.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Mode().IsRegular() {
+			files = append(files, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(files)
+	for _, rel := range files {
+		if err := hashInto(hash, rel, filepath.Join(pkg.Path, rel)); err != nil {
+			return "", err
+		}
+	}
+
+	// Lockfiles at the root decide which dependency versions the build sees
+	if pkg.RootPath != "" && pkg.RootPath != pkg.Path {
+		for _, name := range lockfileNames {
+			err := hashInto(hash, "<root>/"+name, filepath.Join(pkg.RootPath, name))
+			if err != nil && !os.IsNotExist(err) {
+				return "", err
+			}
+		}
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// hashInto adds the name and contents of a file to hash
+func hashInto(hash io.Writer, name string, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	io.WriteString(hash, name+"\x00")
+	_, err = io.Copy(hash, file)
+	return err
+}
+
+// writeJSONFile writes value as JSON, replacing path in one rename
+func writeJSONFile(path string, value interface{}) error {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := siblingPath(path, "tmp")
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
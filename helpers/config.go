@@ -0,0 +1,223 @@
+package helpers
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigFileName is the name of the config file read from packages and the mediatool root
+const ConfigFileName = ".mtcli.yaml"
+
+// CopyTarget describes a folder that is copied from the package into the webapp
+type CopyTarget struct {
+	From string `yaml:"from" json:"from"` // Relative to the package folder
+	To   string `yaml:"to" json:"to"`     // Relative to webapp/node_modules/<package name>
+}
+
+// BuildConfig describes how a package is built and what ends up in the webapp.
+// Unset fields fall back to the defaults of the package's strategy.
+type BuildConfig struct {
+	Commands []string          `yaml:"commands" json:"commands"`
+	Outputs  []string          `yaml:"outputs" json:"outputs"`
+	Copy     []CopyTarget      `yaml:"copy" json:"copy"`
+	Env      map[string]string `yaml:"env" json:"env"`
+	Compare  string            `yaml:"compare" json:"compare"` // How copied files are compared: mtime (default) or hash
+	Watch    WatchConfig       `yaml:"watch" json:"watch"`
+}
+
+// RootConfig represents the .mtcli.yaml file at the mediatool root
+type RootConfig struct {
+	Build      BuildConfig            `yaml:"build"`      // Applied to every package
+	Packages   map[string]BuildConfig `yaml:"packages"`   // Keyed by package name
+	Strategies []StrategyConfig       `yaml:"strategies"` // Extra strategies, see RegisterConfigStrategies
+	Profiles   map[string][]string    `yaml:"profiles"`   // Named package selections, names or globs
+	Server     ServerConfig           `yaml:"server"`     // Log server of watch
+}
+
+// ServerConfig configures the log server started by watch
+type ServerConfig struct {
+	Port int    `yaml:"port"` // Preferred port, a free one is used when it is busy
+	Bind string `yaml:"bind"` // Address to listen on, 127.0.0.1 when empty and 0.0.0.0 for every interface
+}
+
+// Merge returns a copy of c with every field that is set in override replaced.
+// Env is merged key by key instead of being replaced.
+func (c BuildConfig) Merge(override BuildConfig) BuildConfig {
+	merged := c
+	if override.Commands != nil {
+		merged.Commands = override.Commands
+	}
+	if override.Outputs != nil {
+		merged.Outputs = override.Outputs
+	}
+	if override.Copy != nil {
+		merged.Copy = override.Copy
+	}
+	if override.Watch.Include != nil {
+		merged.Watch.Include = override.Watch.Include
+	}
+	if override.Watch.Exclude != nil {
+		merged.Watch.Exclude = override.Watch.Exclude
+	}
+	if override.Compare != "" {
+		merged.Compare = override.Compare
+	}
+	if len(c.Env) > 0 || len(override.Env) > 0 {
+		merged.Env = make(map[string]string, len(c.Env)+len(override.Env))
+		for key, value := range c.Env {
+			merged.Env[key] = value
+		}
+		for key, value := range override.Env {
+			merged.Env[key] = value
+		}
+	}
+	return merged
+}
+
+// CopyTargets returns the outputs and the explicit copy targets as one list
+func (c BuildConfig) CopyTargets() []CopyTarget {
+	targets := make([]CopyTarget, 0, len(c.Outputs)+len(c.Copy))
+	for _, output := range c.Outputs {
+		targets = append(targets, CopyTarget{From: output, To: output})
+	}
+	return append(targets, c.Copy...)
+}
+
+// Validate checks that the outputs and copy targets stay inside the package
+// folder and inside webapp/node_modules/<package name>
+func (c BuildConfig) Validate() error {
+	for _, output := range c.Outputs {
+		if err := validateCopyPath(output); err != nil {
+			return fmt.Errorf("invalid output %q: %w", output, err)
+		}
+	}
+	for _, target := range c.Copy {
+		if err := validateCopyPath(target.From); err != nil {
+			return fmt.Errorf("invalid copy from %q: %w", target.From, err)
+		}
+		if err := validateCopyPath(target.To); err != nil {
+			return fmt.Errorf("invalid copy to %q: %w", target.To, err)
+		}
+	}
+	return nil
+}
+
+// validateCopyPath rejects paths that would replace or leave the folder they are joined to
+func validateCopyPath(path string) error {
+	cleaned := filepath.Clean(filepath.FromSlash(path))
+	switch {
+	case strings.TrimSpace(path) == "":
+		return fmt.Errorf("the path is empty")
+	case filepath.IsAbs(cleaned) || strings.HasPrefix(filepath.ToSlash(path), "/") || filepath.VolumeName(cleaned) != "":
+		return fmt.Errorf("the path has to be relative")
+	case cleaned == ".":
+		return fmt.Errorf("the path can't be the folder itself")
+	case cleaned == ".." || strings.HasPrefix(cleaned, ".."+string(filepath.Separator)):
+		return fmt.Errorf("the path can't leave the folder")
+	}
+	return nil
+}
+
+// LoadRootConfig reads the .mtcli.yaml file at the mediatool root.
+// A missing file results in an empty config.
+func LoadRootConfig(rootPath string) (*RootConfig, error) {
+	var config RootConfig
+	path := filepath.Join(rootPath, ConfigFileName)
+	found, err := readYamlFile(path, &config)
+	if err != nil || !found {
+		return &RootConfig{}, err
+	}
+	if err := config.Build.Validate(); err != nil {
+		return &RootConfig{}, fmt.Errorf("%s: build: %w", path, err)
+	}
+	for name, packageConfig := range config.Packages {
+		if err := packageConfig.Validate(); err != nil {
+			return &RootConfig{}, fmt.Errorf("%s: package %s: %w", path, name, err)
+		}
+	}
+	for _, strategy := range config.Strategies {
+		if err := strategy.BuildConfig.Validate(); err != nil {
+			return &RootConfig{}, fmt.Errorf("%s: strategy %s: %w", path, strategy.Name, err)
+		}
+	}
+	return &config, nil
+}
+
+// LoadPackageConfig reads the build config of a single package, either from its
+// .mtcli.yaml file or from the mtcli key in its package.json. Returns nil when
+// the package has neither.
+func LoadPackageConfig(absolutePath string, packageJson *PackageJson) (*BuildConfig, error) {
+	var config BuildConfig
+	path := filepath.Join(absolutePath, ConfigFileName)
+	found, err := readYamlFile(path, &config)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		if err := config.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return &config, nil
+	}
+	if packageJson != nil && packageJson.Mtcli != nil {
+		if err := packageJson.Mtcli.Validate(); err != nil {
+			return nil, fmt.Errorf("mtcli key of %s: %w", filepath.Join(absolutePath, "package.json"), err)
+		}
+		return packageJson.Mtcli, nil
+	}
+	return nil, nil
+}
+
+// ResolvePackageConfig combines the root defaults, the root entry for the
+// package and the package's own config into a single override
+func ResolvePackageConfig(root *RootConfig, packageName string, packageConfig *BuildConfig) *BuildConfig {
+	var resolved BuildConfig
+	if root != nil {
+		resolved = resolved.Merge(root.Build)
+		if override, ok := root.Packages[packageName]; ok {
+			resolved = resolved.Merge(override)
+		}
+	}
+	if packageConfig != nil {
+		resolved = resolved.Merge(*packageConfig)
+	}
+	return &resolved
+}
+
+// GetBuildConfig returns the effective build config for a package: the
+// strategy defaults with the package's config merged over them
+func GetBuildConfig(pkg NodePackage) BuildConfig {
+	config := getStrategyDefaults(pkg)
+	if pkg.Config != nil {
+		config = config.Merge(*pkg.Config)
+	}
+	return config
+}
+
+// getStrategyDefaults returns the build config used when nothing is configured
+func getStrategyDefaults(pkg NodePackage) BuildConfig {
+	strategy, ok := GetStrategy(pkg.Strategy)
+	if !ok {
+		return BuildConfig{}
+	}
+	return strategy.Generate(pkg)
+}
+
+// readYamlFile decodes a yaml file into out. Reports false if the file does not exist.
+func readYamlFile(path string, out interface{}) (bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	if err := yaml.Unmarshal(data, out); err != nil {
+		return false, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return true, nil
+}
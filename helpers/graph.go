@@ -0,0 +1,280 @@
+package helpers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DependencyGraph holds the dependencies between the packages of a workspace
+type DependencyGraph struct {
+	packages     map[string]NodePackage
+	dependencies map[string][]string // Package name -> workspace packages it depends on
+	dependents   map[string][]string // Package name -> workspace packages depending on it
+}
+
+// NewDependencyGraph builds the graph for the given packages. Only dependencies
+// on other packages in the list become edges.
+func NewDependencyGraph(packages []NodePackage) *DependencyGraph {
+	graph := &DependencyGraph{
+		packages:     make(map[string]NodePackage, len(packages)),
+		dependencies: make(map[string][]string),
+		dependents:   make(map[string][]string),
+	}
+	for _, pkg := range packages {
+		if pkg.PackageJson != nil {
+			graph.packages[pkg.PackageJson.Name] = pkg
+		}
+	}
+	for name, pkg := range graph.packages {
+		for dependency := range pkg.PackageJson.Dependencies {
+			if _, ok := graph.packages[dependency]; ok && dependency != name {
+				graph.addEdge(name, dependency)
+			}
+		}
+	}
+	graph.sortEdges()
+	return graph
+}
+
+// Subgraph returns the graph limited to the selected packages. Dependencies that
+// go through packages outside the selection are kept as direct edges, so a
+// selected package still waits for a selected package it depends on indirectly.
+func (g *DependencyGraph) Subgraph(selected []NodePackage) *DependencyGraph {
+	subgraph := &DependencyGraph{
+		packages:     make(map[string]NodePackage, len(selected)),
+		dependencies: make(map[string][]string),
+		dependents:   make(map[string][]string),
+	}
+	for _, pkg := range selected {
+		if pkg.PackageJson != nil {
+			subgraph.packages[pkg.PackageJson.Name] = pkg
+		}
+	}
+	for name := range subgraph.packages {
+		visited := map[string]bool{name: true}
+		var visit func(current string)
+		visit = func(current string) {
+			for _, dependency := range g.dependencies[current] {
+				if visited[dependency] {
+					continue
+				}
+				visited[dependency] = true
+				if _, ok := subgraph.packages[dependency]; ok {
+					subgraph.addEdge(name, dependency)
+					continue
+				}
+				visit(dependency)
+			}
+		}
+		visit(name)
+	}
+	subgraph.sortEdges()
+	return subgraph
+}
+
+// Packages returns the packages in the graph sorted by name
+func (g *DependencyGraph) Packages() []NodePackage {
+	packages := make([]NodePackage, 0, len(g.packages))
+	for _, name := range g.names() {
+		packages = append(packages, g.packages[name])
+	}
+	return packages
+}
+
+// Dependencies returns the names of the packages that name depends on
+func (g *DependencyGraph) Dependencies(name string) []string {
+	return g.dependencies[name]
+}
+
+// Dependents returns the names of the packages that depend on name
+func (g *DependencyGraph) Dependents(name string) []string {
+	return g.dependents[name]
+}
+
+// Order returns the packages sorted so every package comes after its
+// dependencies. Returns an error if the dependencies contain a cycle.
+func (g *DependencyGraph) Order() ([]NodePackage, error) {
+	if err := g.checkCycles(); err != nil {
+		return nil, err
+	}
+
+	remaining := make(map[string]int, len(g.packages))
+	var ready []string
+	for _, name := range g.names() {
+		remaining[name] = len(g.dependencies[name])
+		if remaining[name] == 0 {
+			ready = append(ready, name)
+		}
+	}
+
+	ordered := make([]NodePackage, 0, len(g.packages))
+	for len(ready) > 0 {
+		name := ready[0]
+		ready = ready[1:]
+		ordered = append(ordered, g.packages[name])
+		for _, dependent := range g.dependents[name] {
+			remaining[dependent]--
+			if remaining[dependent] == 0 {
+				ready = append(ready, dependent)
+			}
+		}
+	}
+	return ordered, nil
+}
+
+// Run calls build for every package in the graph. A package is built once all
+// its dependencies have been built successfully, packages that don't depend on
+// each other are built in parallel. Packages whose dependencies failed are
+// skipped, as are packages that did not start before ctx was cancelled.
+// Returns the error of every package that failed or was skipped.
+func (g *DependencyGraph) Run(ctx context.Context, build func(ctx context.Context, pkg NodePackage) error) (map[string]error, error) {
+	if err := g.checkCycles(); err != nil {
+		return nil, err
+	}
+
+	var (
+		mu        sync.Mutex
+		wg        sync.WaitGroup
+		failures  = make(map[string]error)
+		remaining = make(map[string]int, len(g.packages))
+	)
+
+	var start func(name string)
+	// finish marks name as done and starts or skips the dependents that were waiting for it
+	var finish func(name string, err error)
+	finish = func(name string, err error) {
+		mu.Lock()
+		if err != nil {
+			failures[name] = err
+		}
+		var next []string
+		for _, dependent := range g.dependents[name] {
+			remaining[dependent]--
+			if remaining[dependent] == 0 {
+				next = append(next, dependent)
+			}
+		}
+		mu.Unlock()
+
+		for _, dependent := range next {
+			if failed, failure := g.failedDependency(dependent, failures, &mu); failed != "" {
+				if errors.Is(failure, context.Canceled) {
+					finish(dependent, fmt.Errorf("skipped because the build was cancelled: %w", context.Canceled))
+				} else {
+					finish(dependent, fmt.Errorf("skipped because dependency %s failed", failed))
+				}
+				continue
+			}
+			start(dependent)
+		}
+	}
+	start = func(name string) {
+		if err := ctx.Err(); err != nil {
+			finish(name, fmt.Errorf("skipped because the build was cancelled: %w", err))
+			return
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			finish(name, build(ctx, g.packages[name]))
+		}()
+	}
+
+	var ready []string
+	for _, name := range g.names() {
+		remaining[name] = len(g.dependencies[name])
+		if remaining[name] == 0 {
+			ready = append(ready, name)
+		}
+	}
+	for _, name := range ready {
+		start(name)
+	}
+	wg.Wait()
+
+	return failures, nil
+}
+
+// failedDependency returns the first dependency of name that failed, and its error
+func (g *DependencyGraph) failedDependency(name string, failures map[string]error, mu *sync.Mutex) (string, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, dependency := range g.dependencies[name] {
+		if failures[dependency] != nil {
+			return dependency, failures[dependency]
+		}
+	}
+	return "", nil
+}
+
+// checkCycles returns an error describing the first dependency cycle found
+func (g *DependencyGraph) checkCycles() error {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int, len(g.packages))
+	var stack []string
+
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case visiting:
+			start := 0
+			for i, entry := range stack {
+				if entry == name {
+					start = i
+				}
+			}
+			cycle := append(append([]string{}, stack[start:]...), name)
+			return fmt.Errorf("dependency cycle between packages: %s", strings.Join(cycle, " -> "))
+		case visited:
+			return nil
+		}
+		state[name] = visiting
+		stack = append(stack, name)
+		for _, dependency := range g.dependencies[name] {
+			if err := visit(dependency); err != nil {
+				return err
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[name] = visited
+		return nil
+	}
+
+	for _, name := range g.names() {
+		if err := visit(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *DependencyGraph) addEdge(from string, to string) {
+	g.dependencies[from] = append(g.dependencies[from], to)
+	g.dependents[to] = append(g.dependents[to], from)
+}
+
+func (g *DependencyGraph) sortEdges() {
+	for _, edges := range g.dependencies {
+		sort.Strings(edges)
+	}
+	for _, edges := range g.dependents {
+		sort.Strings(edges)
+	}
+}
+
+func (g *DependencyGraph) names() []string {
+	names := make([]string, 0, len(g.packages))
+	for name := range g.packages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -8,11 +8,13 @@ import (
 	"math/rand"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/gen2brain/beeep"
 	"github.com/ktr0731/go-fuzzyfinder"
+	"golang.org/x/term"
 )
 
 // GeneratePortNumber handle generating port number
@@ -48,10 +50,23 @@ type PackageJson struct {
 	Scripts          map[string]string `json:"scripts"`
 	PackageManager   string            `json:"packageManager"`
 	Dependencies     map[string]string `json:"dependencies"`
+	DevDependencies  map[string]string `json:"devDependencies"`
 	PeerDependencies map[string]string `json:"peerDependencies"`
+	Mtcli            *BuildConfig      `json:"mtcli"`
 	// Add other fields as needed
 }
 
+// HasDependency reports whether name is listed in any of the dependency fields
+func (p *PackageJson) HasDependency(name string) bool {
+	if p == nil {
+		return false
+	}
+	_, inDependencies := p.Dependencies[name]
+	_, inDevDependencies := p.DevDependencies[name]
+	_, inPeerDependencies := p.PeerDependencies[name]
+	return inDependencies || inDevDependencies || inPeerDependencies
+}
+
 // LinkingStrategy represents different strategies for linking packages
 type LinkingStrategy string
 
@@ -61,6 +76,7 @@ const (
 	AMEND_NATIVE      LinkingStrategy = "AMEND_NATIVE"
 	MAKEFILE_BUILD    LinkingStrategy = "MAKEFILE_BUILD"
 	TRANSPILED_YARN   LinkingStrategy = "TRANSPILED_YARN"
+	UNKNOWN           LinkingStrategy = "UNKNOWN"
 )
 
 // GetPackageJsonForPath reads and parses the package.json file at the given path
@@ -83,64 +99,16 @@ func GetPackageJsonForPath(absolutePath string, required bool) (*PackageJson, er
 	return &packageJson, nil
 }
 
-// strategyChecker is a function that checks if a strategy applies
-type strategyChecker func(folderItems map[string]bool, packageJson *PackageJson, absolutePath string) bool
-
-// getStrategyCheckers returns a map of strategy checkers
-func getStrategyCheckers() map[LinkingStrategy]strategyChecker {
-	return map[LinkingStrategy]strategyChecker{
-		TRANSPILED_YARN: func(folderItems map[string]bool, packageJson *PackageJson, absolutePath string) bool {
-			return (folderItems["rollup.config.mjs"] ||
-				folderItems["rollup.config.js"]) &&
-				strings.Contains(packageJson.PackageManager, "yarn")
-		},
-		TRANSPILED: func(folderItems map[string]bool, packageJson *PackageJson, absolutePath string) bool {
-			return folderItems["rollup.config.mjs"] ||
-				folderItems["rollup.config.js"]
-		},
-		TRANSPILED_LEGACY: func(_ map[string]bool, packageJson *PackageJson, absolutePath string) bool {
-			if packageJson == nil || packageJson.Scripts == nil {
-				return false
-			}
-			_, hasBuild := packageJson.Scripts["build"]
-			return hasBuild
-		},
-		AMEND_NATIVE: func(folderItems map[string]bool, _ *PackageJson, absolutePath string) bool {
-			return folderItems["amend"] && folderItems["lib"]
-		},
-		MAKEFILE_BUILD: func(folderItems map[string]bool, _ *PackageJson, absolutePath string) bool {
-			return folderItems["Makefile"]
-		},
-	}
-}
-
 // GetOptimalStrategy determines the optimal linking strategy based on folder contents and package.json
 func GetOptimalStrategy(folderItems map[string]bool, packageJson *PackageJson, absolutePath string) LinkingStrategy {
-	checkers := getStrategyCheckers()
-	strategies := getOrderedStrategies()
-
-	// Iterate through strategies in a defined order
-	for _, strategy := range strategies {
-		if checker, exists := checkers[strategy]; exists {
-			if checker(folderItems, packageJson, absolutePath) {
-				return strategy
-			}
+	// Iterate through strategies in priority order
+	for _, strategy := range RegisteredStrategies() {
+		if strategy.Detect(folderItems, packageJson, absolutePath) {
+			return strategy.Name
 		}
 	}
 
-	return "UNKNOWN"
-}
-
-// This function would define the order of strategy evaluation
-func getOrderedStrategies() []LinkingStrategy {
-	return []LinkingStrategy{
-		// List strategies in priority order
-		TRANSPILED_YARN,
-		TRANSPILED,
-		TRANSPILED_LEGACY,
-		AMEND_NATIVE,
-		MAKEFILE_BUILD,
-	}
+	return UNKNOWN
 }
 
 // GetLinkingStrategyForPackage analyzes a package directory and determines the appropriate linking strategy
@@ -177,6 +145,8 @@ type NodePackage struct {
 	IsMediatoolRoot bool            `json:"isMediatoolRoot"`
 	FolderItems     map[string]bool `json:"folderItems"`
 	IsFrontend      bool            `json:"isFrontend"`
+	Config          *BuildConfig    `json:"config"`
+	RootPath        string          `json:"rootPath"` // The folder FindNodePackages searched
 }
 
 // FindNodePackages recursively finds all Node.js packages in the given directory
@@ -184,7 +154,20 @@ type NodePackage struct {
 func FindNodePackages(rootDir string) ([]NodePackage, error) {
 	var packages []NodePackage
 
-	err := filepath.Walk(rootDir, func(path string, info os.FileInfo, err error) error {
+	rootPath, err := filepath.Abs(rootDir)
+	if err != nil {
+		return nil, err
+	}
+
+	rootConfig, err := LoadRootConfig(rootDir)
+	if err != nil {
+		return nil, err
+	}
+	if err := RegisterConfigStrategies(rootConfig); err != nil {
+		return nil, err
+	}
+
+	err = filepath.Walk(rootDir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
@@ -204,24 +187,12 @@ func FindNodePackages(rootDir string) ([]NodePackage, error) {
 					return err
 				}
 
-				packageJson, err := GetPackageJsonForPath(absPath, false)
+				pkg, err := loadNodePackage(absPath, rootPath, rootConfig)
 				if err != nil {
-					// Just skip this package if we can't read its package.json
-					return nil
+					return err
 				}
-
-				if packageJson != nil {
-					folderItems := GetFolderItems(absPath)
-					strategy := GetOptimalStrategy(folderItems, packageJson, absPath)
-					isFrontend := strings.Contains(packageJson.Name, "frontend") || packageJson.Dependencies["react"] != "" || packageJson.PeerDependencies["react"] != ""
-					packages = append(packages, NodePackage{
-						Path:            absPath,
-						PackageJson:     packageJson,
-						Strategy:        strategy,
-						IsMediatoolRoot: IsMediatoolRoot(absPath),
-						FolderItems:     folderItems,
-						IsFrontend:      isFrontend,
-					})
+				if pkg != nil {
+					packages = append(packages, *pkg)
 				}
 			}
 		}
@@ -236,6 +207,34 @@ func FindNodePackages(rootDir string) ([]NodePackage, error) {
 	return packages, nil
 }
 
+// loadNodePackage reads the package in the folder at absPath and detects its
+// strategy. Returns nil if its package.json can't be read.
+func loadNodePackage(absPath string, rootPath string, rootConfig *RootConfig) (*NodePackage, error) {
+	packageJson, err := GetPackageJsonForPath(absPath, false)
+	if err != nil || packageJson == nil {
+		// Just skip this package if we can't read its package.json
+		return nil, nil
+	}
+
+	folderItems := GetFolderItems(absPath)
+	strategy := GetOptimalStrategy(folderItems, packageJson, absPath)
+	isFrontend := strings.Contains(packageJson.Name, "frontend") || packageJson.Dependencies["react"] != "" || packageJson.PeerDependencies["react"] != ""
+	packageConfig, err := LoadPackageConfig(absPath, packageJson)
+	if err != nil {
+		return nil, err
+	}
+	return &NodePackage{
+		Path:            absPath,
+		PackageJson:     packageJson,
+		Strategy:        strategy,
+		IsMediatoolRoot: IsMediatoolRoot(absPath),
+		FolderItems:     folderItems,
+		IsFrontend:      isFrontend,
+		Config:          ResolvePackageConfig(rootConfig, packageJson.Name, packageConfig),
+		RootPath:        rootPath,
+	}, nil
+}
+
 func GetFolderItems(path string) map[string]bool {
 	files, err := os.ReadDir(path)
 	if err != nil {
@@ -249,30 +248,54 @@ func GetFolderItems(path string) map[string]bool {
 	return folderItems
 }
 
-func SelectPackages(packages []NodePackage) []NodePackage {
-	idx, err := fuzzyfinder.FindMulti(
-		packages,
-		func(i int) string {
-			return packages[i].PackageJson.Name
-		},
+// SelectPackages lets the user pick packages with the fuzzy finder. Shortcuts
+// are listed first, picking one selects all the packages it names.
+func SelectPackages(packages []NodePackage, shortcuts ...SelectionShortcut) ([]NodePackage, error) {
+	if !term.IsTerminal(int(os.Stdin.Fd())) || !term.IsTerminal(int(os.Stdout.Fd())) {
+		return nil, ErrNoTerminal
+	}
+
+	options := []fuzzyfinder.Option{
 		fuzzyfinder.WithPreviewWindow(func(i, w, h int) string {
-			if i == -1 {
+			switch {
+			case i == -1:
 				return ""
+			case i < len(shortcuts):
+				return shortcuts[i].Label + ":\n" + strings.Join(append(slices.Clone(shortcuts[i].Names), shortcuts[i].Packages...), "\n")
+			}
+			pkg := packages[i-len(shortcuts)]
+			return fmt.Sprintf("%s: %s", pkg.PackageJson.Name, pkg.Strategy)
+		}),
+	}
+	if len(shortcuts) > 0 {
+		options = append(options, fuzzyfinder.WithHeader("Entries in [brackets] select saved selections, Tab picks several entries"))
+	}
+	idx, err := fuzzyfinder.FindMulti(
+		make([]struct{}, len(shortcuts)+len(packages)),
+		func(i int) string {
+			if i < len(shortcuts) {
+				return "[" + shortcuts[i].Label + "]"
 			}
-			return fmt.Sprintf("%s: %s",
-				packages[i].PackageJson.Name,
-				packages[i].Strategy)
-		}))
+			return packages[i-len(shortcuts)].PackageJson.Name
+		},
+		options...)
 	if err != nil {
-		log.Fatal(err)
+		if errors.Is(err, fuzzyfinder.ErrAbort) {
+			return nil, errors.New("package selection cancelled")
+		}
+		return nil, err
 	}
 
-	// Create a new slice to hold the selected packages
-	selected := make([]NodePackage, len(idx))
-	for i, index := range idx {
-		selected[i] = packages[index]
+	var chosenShortcuts []SelectionShortcut
+	var chosen []string
+	for _, index := range idx {
+		if index < len(shortcuts) {
+			chosenShortcuts = append(chosenShortcuts, shortcuts[index])
+		} else {
+			chosen = append(chosen, packages[index-len(shortcuts)].PackageJson.Name)
+		}
 	}
-	return selected
+	return expandShortcuts(packages, chosenShortcuts, chosen), nil
 }
 
 func GetAbsolutePath(path string) (string, error) {
@@ -300,7 +323,7 @@ func GetBuildablePackages(packages []NodePackage) []NodePackage {
 	//filter out root packages webapp and oackages without strategy
 	buildablePackages := []NodePackage{}
 	for _, pkg := range packages {
-		if pkg.Strategy != "UNKNOWN" && pkg.PackageJson.Name != "mediatool-webapp" && !pkg.IsMediatoolRoot {
+		if pkg.Strategy != UNKNOWN && pkg.PackageJson.Name != "mediatool-webapp" && !pkg.IsMediatoolRoot {
 			buildablePackages = append(buildablePackages, pkg)
 		}
 	}
@@ -0,0 +1,226 @@
+package helpers
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ignoreFileNames are the ignore files read from the package folder, in order
+var ignoreFileNames = []string{".gitignore", ".npmignore"}
+
+// alwaysIgnored can't be brought back with include patterns. Watching them is
+// either wasteful or makes every build trigger the next one.
+var alwaysIgnored = []string{"node_modules/", ".git/", StateDirName + "/"}
+
+// editorTempFiles are swap, backup and temporary files editors write next to the
+// file being edited. 4913 is the file Vim uses to check if a folder is writable.
+var editorTempFiles = []string{"*.swp", "*.swx", "*~", ".#*", "4913", "*___jb_tmp___", "*___jb_old___"}
+
+// WatchConfig selects which files of a package trigger a rebuild in watch mode.
+// Patterns use .gitignore syntax and are relative to the package folder.
+type WatchConfig struct {
+	Include []string `yaml:"include" json:"include"` // Watched even if an ignore file or exclude skips them
+	Exclude []string `yaml:"exclude" json:"exclude"` // Ignored in addition to .gitignore and .npmignore
+}
+
+// ignoreRule is a single line of an ignore file
+type ignoreRule struct {
+	pattern *regexp.Regexp
+	negate  bool // The line started with ! and brings back a path ignored before
+	dirOnly bool // The line ended with / and only matches folders
+}
+
+// IgnoreMatcher decides which paths of a package are left out of watch mode
+type IgnoreMatcher struct {
+	root  string
+	fixed []ignoreRule // Ignored no matter what the other rules say
+	rules []ignoreRule // Later rules win over earlier ones, like in .gitignore
+}
+
+// NewIgnoreMatcher creates the matcher for a package. It ignores:
+//   - node_modules, .git and .mtcli folders
+//   - the outputs of packages that have build commands, so a build can't trigger itself
+//   - editor swap and backup files
+//   - everything matched by the package's .gitignore and .npmignore
+//   - the exclude patterns of the watch config, unless an include pattern matches
+func NewIgnoreMatcher(pkg NodePackage) (*IgnoreMatcher, error) {
+	matcher := &IgnoreMatcher{root: pkg.Path}
+	config := GetBuildConfig(pkg)
+
+	matcher.fixed = append(matcher.fixed, parseIgnoreLines(alwaysIgnored)...)
+	matcher.fixed = append(matcher.fixed, parseIgnoreLines(editorTempFiles)...)
+	if len(config.Commands) > 0 {
+		var outputs []string
+		for _, target := range config.CopyTargets() {
+			from := filepath.ToSlash(filepath.Clean(target.From))
+			if from == "." || strings.HasPrefix(from, "../") {
+				continue
+			}
+			// Anchored, and not limited to folders, so a removed output folder matches too
+			outputs = append(outputs, "/"+strings.TrimSuffix(from, "/"))
+		}
+		matcher.fixed = append(matcher.fixed, parseIgnoreLines(outputs)...)
+	}
+
+	for _, name := range ignoreFileNames {
+		lines, err := readIgnoreFile(filepath.Join(pkg.Path, name))
+		if err != nil {
+			return nil, err
+		}
+		matcher.rules = append(matcher.rules, parseIgnoreLines(lines)...)
+	}
+	matcher.rules = append(matcher.rules, parseIgnoreLines(config.Watch.Exclude)...)
+	for _, pattern := range config.Watch.Include {
+		matcher.rules = append(matcher.rules, parseIgnoreLines([]string{"!" + strings.TrimPrefix(pattern, "!")})...)
+	}
+	return matcher, nil
+}
+
+// Ignored reports whether changes to path should not trigger a build. path is
+// either absolute or relative to the package folder. Like git, everything in
+// an ignored folder is ignored, even if a later rule matches the file itself.
+func (m *IgnoreMatcher) Ignored(path string, isDir bool) bool {
+	if filepath.IsAbs(path) {
+		relative, err := filepath.Rel(m.root, path)
+		if err != nil {
+			return false
+		}
+		path = relative
+	}
+	path = filepath.ToSlash(filepath.Clean(path))
+	if path == "." || strings.HasPrefix(path, "../") {
+		return false
+	}
+
+	parts := strings.Split(path, "/")
+	for i := range parts {
+		current := strings.Join(parts[:i+1], "/")
+		currentIsDir := isDir || i < len(parts)-1
+		if matchIgnoreRules(m.fixed, current, currentIsDir) || matchIgnoreRules(m.rules, current, currentIsDir) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchIgnoreRules returns whether the last rule matching path ignores it
+func matchIgnoreRules(rules []ignoreRule, path string, isDir bool) bool {
+	ignored := false
+	for _, rule := range rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if rule.pattern.MatchString(path) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// readIgnoreFile returns the lines of an ignore file, or nothing if it does not exist
+func readIgnoreFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines, scanner.Err()
+}
+
+// parseIgnoreLines turns lines in .gitignore syntax into rules, skipping comments and blank lines
+func parseIgnoreLines(lines []string) []ignoreRule {
+	var rules []ignoreRule
+	for _, line := range lines {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var rule ignoreRule
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+
+		// Patterns with a slash are relative to the package folder, others match at any depth
+		anchored := strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+		expression := globToRegexp(line)
+		if !anchored {
+			expression = "(?:.*/)?" + expression
+		}
+		pattern, err := regexp.Compile("^" + expression + "$")
+		if err != nil {
+			// Broken patterns are skipped, like git does
+			continue
+		}
+		rule.pattern = pattern
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// globToRegexp converts a .gitignore glob into a regular expression
+func globToRegexp(glob string) string {
+	var expression strings.Builder
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			if strings.HasPrefix(glob[i:], "**") {
+				switch {
+				case strings.HasPrefix(glob[i:], "**/"):
+					expression.WriteString("(?:.*/)?")
+					i += 2
+				default:
+					expression.WriteString(".*")
+					i++
+				}
+				continue
+			}
+			expression.WriteString("[^/]*")
+		case '?':
+			expression.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end == -1 {
+				expression.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			expression.WriteString("[" + class + "]")
+			i += end + 1
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+				expression.WriteString(regexp.QuoteMeta(string(glob[i])))
+			}
+		default:
+			expression.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return expression.String()
+}
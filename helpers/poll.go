@@ -0,0 +1,214 @@
+package helpers
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultPollInterval is how often a PollWatcher scans when no interval is given
+const DefaultPollInterval = time.Second
+
+// PackageWatcher reports the changes below package folders. FileWatcher uses
+// file system events, PollWatcher scans the folders at an interval.
+type PackageWatcher interface {
+	// Add starts watching path and returns the channel that receives its changes
+	Add(path string, ignore *IgnoreMatcher) (<-chan WatchEvent, error)
+	// Remove stops watching a path added with Add
+	Remove(path string)
+	// Close stops watching everything
+	Close() error
+}
+
+// PollWatcher finds changes by comparing the size and modification time of
+// every file at an interval. It works on bind mounts and shared folders of
+// virtual machines, where file system events are not delivered.
+type PollWatcher struct {
+	interval time.Duration
+	logger   *log.Logger
+	mu       sync.Mutex
+	roots    map[string]*pollRoot
+}
+
+// pollRoot is a folder added with Add and the state of its files at the last scan
+type pollRoot struct {
+	path   string
+	ignore *IgnoreMatcher
+	events chan WatchEvent
+	stop   chan struct{}
+	files  map[string]polledFile
+}
+
+type polledFile struct {
+	size    int64
+	modTime time.Time
+	isDir   bool
+}
+
+// NewPollWatcher creates a watcher scanning every interval.
+// An interval of zero or less uses DefaultPollInterval.
+func NewPollWatcher(interval time.Duration, logger *log.Logger) *PollWatcher {
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+	if logger == nil {
+		logger = log.Default()
+	}
+	return &PollWatcher{
+		interval: interval,
+		logger:   logger,
+		roots:    make(map[string]*pollRoot),
+	}
+}
+
+// Interval returns the time between two scans
+func (w *PollWatcher) Interval() time.Duration {
+	return w.interval
+}
+
+// Add scans path once and then keeps scanning it for changes to files that
+// ignore does not skip
+func (w *PollWatcher) Add(path string, ignore *IgnoreMatcher) (<-chan WatchEvent, error) {
+	path = filepath.Clean(path)
+	root := &pollRoot{
+		path:   path,
+		ignore: ignore,
+		events: make(chan WatchEvent, 100),
+		stop:   make(chan struct{}),
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.roots[path]; ok {
+		return nil, fmt.Errorf("%s is already watched", path)
+	}
+	files, err := w.scan(root)
+	if err != nil {
+		return nil, err
+	}
+	root.files = files
+	w.roots[path] = root
+	go w.poll(root)
+	return root.events, nil
+}
+
+// Remove stops scanning a path added with Add
+func (w *PollWatcher) Remove(path string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if root, ok := w.roots[filepath.Clean(path)]; ok {
+		close(root.stop)
+		delete(w.roots, root.path)
+	}
+}
+
+// Close stops scanning every path
+func (w *PollWatcher) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for path, root := range w.roots {
+		close(root.stop)
+		delete(w.roots, path)
+	}
+	return nil
+}
+
+func (w *PollWatcher) poll(root *pollRoot) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-root.stop:
+			return
+		case <-ticker.C:
+		}
+
+		w.mu.Lock()
+		files, err := w.scan(root)
+		w.mu.Unlock()
+		if err != nil {
+			w.logger.Printf("Failed to scan %s: %v", root.path, err)
+			continue
+		}
+		events := diffPolledFiles(root.files, files)
+		root.files = files
+		for _, event := range events {
+			select {
+			case root.events <- event:
+			case <-root.stop:
+				return
+			}
+		}
+	}
+}
+
+// scan returns the state of every file below root that is not ignored.
+// Folders of other roots are left to those roots. Must be called with w.mu held.
+func (w *PollWatcher) scan(root *pollRoot) (map[string]polledFile, error) {
+	files := make(map[string]polledFile)
+	err := filepath.WalkDir(root.path, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			// Files can disappear while we scan, e.g. during a git checkout
+			if os.IsNotExist(err) && path != root.path {
+				return nil
+			}
+			return err
+		}
+		if path != root.path {
+			if entry.IsDir() && w.roots[path] != nil {
+				return filepath.SkipDir
+			}
+			if root.ignore != nil && root.ignore.Ignored(path, entry.IsDir()) {
+				if entry.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+		}
+		info, err := entry.Info()
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		files[path] = polledFile{size: info.Size(), modTime: info.ModTime(), isDir: entry.IsDir()}
+		return nil
+	})
+	return files, err
+}
+
+// diffPolledFiles returns the events that turn previous into current. Only the
+// top folder is reported when a whole folder was created or removed.
+func diffPolledFiles(previous map[string]polledFile, current map[string]polledFile) []WatchEvent {
+	var events []WatchEvent
+	for _, path := range sortedKeys(current) {
+		file := current[path]
+		old, existed := previous[path]
+		switch {
+		case !existed || old.isDir != file.isDir:
+			if _, parentExisted := previous[filepath.Dir(path)]; !parentExisted && current[filepath.Dir(path)].isDir {
+				continue
+			}
+			events = append(events, WatchEvent{Path: path, Op: WatchCreate, IsDir: file.isDir})
+		case !file.isDir && (old.size != file.size || !old.modTime.Equal(file.modTime)):
+			events = append(events, WatchEvent{Path: path, Op: WatchWrite})
+		}
+	}
+	for _, path := range sortedKeys(previous) {
+		if _, exists := current[path]; exists {
+			continue
+		}
+		if _, parentExists := current[filepath.Dir(path)]; !parentExists && previous[filepath.Dir(path)].isDir {
+			continue
+		}
+		events = append(events, WatchEvent{Path: path, Op: WatchRemove, IsDir: previous[path].isDir})
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Path < events[j].Path })
+	return events
+}
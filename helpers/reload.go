@@ -0,0 +1,133 @@
+package helpers
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// metadataFileNames are the files in a package folder whose contents decide how
+// it is detected, built and watched. For other files like rollup.config.js or a
+// Makefile only their existence matters to strategy detection.
+var metadataFileNames = []string{"package.json", ConfigFileName, ".gitignore", ".npmignore"}
+
+// ReloadNodePackage reads the package at pkg.Path again and detects its
+// strategy and build config like FindNodePackages does
+func ReloadNodePackage(pkg NodePackage) (NodePackage, error) {
+	var rootConfig *RootConfig
+	if pkg.RootPath != "" {
+		var err error
+		rootConfig, err = LoadRootConfig(pkg.RootPath)
+		if err != nil {
+			return pkg, err
+		}
+	}
+	reloaded, err := loadNodePackage(pkg.Path, pkg.RootPath, rootConfig)
+	if err != nil {
+		return pkg, err
+	}
+	if reloaded == nil {
+		return pkg, fmt.Errorf("failed to read %s", filepath.Join(pkg.Path, "package.json"))
+	}
+	return *reloaded, nil
+}
+
+// IsMetadataChange reports whether event may change how pkg is detected or
+// built, so the package should be reloaded with ReloadNodePackage. That is a
+// change to package.json, .mtcli.yaml or an ignore file, or a file or folder
+// that appeared in or disappeared from the package folder.
+func IsMetadataChange(pkg NodePackage, event WatchEvent) bool {
+	path := event.Path
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(pkg.Path, path)
+	}
+	if filepath.Dir(path) != filepath.Clean(pkg.Path) {
+		return false
+	}
+	if event.Has(WatchCreate) || event.Has(WatchRemove) || event.Has(WatchRename) {
+		return true
+	}
+	return slices.Contains(metadataFileNames, filepath.Base(path))
+}
+
+// DescribePackageChanges lists what differs between two versions of a
+// package in a way that is readable in the log. Returns nil if nothing
+// that matters for building it changed.
+func DescribePackageChanges(old NodePackage, reloaded NodePackage) []string {
+	var changes []string
+	oldName, newName := packageName(old), packageName(reloaded)
+	if oldName != newName {
+		changes = append(changes, fmt.Sprintf("name changed from %s to %s", oldName, newName))
+	}
+	if old.Strategy != reloaded.Strategy {
+		changes = append(changes, fmt.Sprintf("strategy changed from %s to %s", old.Strategy, reloaded.Strategy))
+	}
+	if old.IsFrontend != reloaded.IsFrontend {
+		changes = append(changes, fmt.Sprintf("frontend changed from %t to %t", old.IsFrontend, reloaded.IsFrontend))
+	}
+
+	oldConfig, newConfig := GetBuildConfig(old), GetBuildConfig(reloaded)
+	if !reflect.DeepEqual(oldConfig.Commands, newConfig.Commands) {
+		changes = append(changes, fmt.Sprintf("build commands changed from %s to %s", describeList(oldConfig.Commands), describeList(newConfig.Commands)))
+	}
+	if !reflect.DeepEqual(oldConfig.CopyTargets(), newConfig.CopyTargets()) {
+		changes = append(changes, fmt.Sprintf("outputs changed from %s to %s", describeCopyTargets(oldConfig.CopyTargets()), describeCopyTargets(newConfig.CopyTargets())))
+	}
+	if !reflect.DeepEqual(oldConfig.Env, newConfig.Env) || oldConfig.Compare != newConfig.Compare {
+		changes = append(changes, "build settings changed")
+	}
+	if !reflect.DeepEqual(oldConfig.Watch, newConfig.Watch) {
+		changes = append(changes, "watch patterns changed")
+	}
+
+	oldDependencies, newDependencies := dependencyNames(old), dependencyNames(reloaded)
+	for _, name := range newDependencies {
+		if !slices.Contains(oldDependencies, name) {
+			changes = append(changes, "now depends on "+name)
+		}
+	}
+	for _, name := range oldDependencies {
+		if !slices.Contains(newDependencies, name) {
+			changes = append(changes, "no longer depends on "+name)
+		}
+	}
+	return changes
+}
+
+func packageName(pkg NodePackage) string {
+	if pkg.PackageJson == nil {
+		return ""
+	}
+	return pkg.PackageJson.Name
+}
+
+// dependencyNames returns the sorted names of the dependencies of pkg
+func dependencyNames(pkg NodePackage) []string {
+	if pkg.PackageJson == nil {
+		return nil
+	}
+	names := make([]string, 0, len(pkg.PackageJson.Dependencies))
+	for name := range pkg.PackageJson.Dependencies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func describeList(values []string) string {
+	if len(values) == 0 {
+		return "none"
+	}
+	return strings.Join(values, ", ")
+}
+
+func describeCopyTargets(targets []CopyTarget) string {
+	values := make([]string, 0, len(targets))
+	for _, target := range targets {
+		values = append(values, target.From)
+	}
+	return describeList(values)
+}
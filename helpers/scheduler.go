@@ -0,0 +1,149 @@
+package helpers
+
+import (
+	"log"
+	"runtime"
+	"sync"
+)
+
+// Scheduler runs builds in FIFO order with a limit on how many run at once.
+// Jobs are keyed by package name: a package that is already waiting is not
+// queued twice, and two jobs for the same package never run at the same time.
+type Scheduler struct {
+	jobs    int
+	logger  *log.Logger
+	mu      sync.Mutex
+	queue   []*scheduledJob
+	pending map[string]*scheduledJob
+	running map[string]bool
+	wg      sync.WaitGroup
+}
+
+type scheduledJob struct {
+	key    string
+	run    func()
+	done   chan struct{}
+	waited bool // The job could not start right away
+}
+
+// NewScheduler creates a scheduler running at most jobs builds at once.
+// A limit below one means one build per CPU.
+func NewScheduler(jobs int, logger *log.Logger) *Scheduler {
+	if jobs < 1 {
+		jobs = runtime.NumCPU()
+	}
+	if logger == nil {
+		logger = log.Default()
+	}
+	return &Scheduler{
+		jobs:    jobs,
+		logger:  logger,
+		pending: make(map[string]*scheduledJob),
+		running: make(map[string]bool),
+	}
+}
+
+// Jobs returns how many builds may run at once
+func (s *Scheduler) Jobs() int {
+	return s.jobs
+}
+
+// Submit queues run under key. The returned channel is closed once run has
+// finished. If a job for key is already waiting, run is dropped and the
+// channel of the waiting job is returned with queued set to false.
+func (s *Scheduler) Submit(key string, run func()) (done <-chan struct{}, queued bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existing, ok := s.pending[key]; ok {
+		s.logger.Printf("%s is already queued", key)
+		return existing.done, false
+	}
+
+	job := &scheduledJob{key: key, run: run, done: make(chan struct{})}
+	s.queue = append(s.queue, job)
+	s.pending[key] = job
+	s.wg.Add(1)
+	if len(s.running) >= s.jobs || s.running[key] {
+		job.waited = true
+		s.logger.Printf("Queued %s (%d waiting, %d/%d running)", key, len(s.queue), len(s.running), s.jobs)
+	}
+	s.startJobs()
+	return job.done, true
+}
+
+// Drop removes the waiting job for key without running it and closes its
+// channel. Reports false if no job for key is waiting, a running job is not
+// affected.
+func (s *Scheduler) Drop(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.pending[key]
+	if !ok {
+		return false
+	}
+	delete(s.pending, key)
+	for i, queued := range s.queue {
+		if queued == job {
+			s.queue = append(s.queue[:i], s.queue[i+1:]...)
+			break
+		}
+	}
+	close(job.done)
+	s.wg.Done()
+	return true
+}
+
+// Wait blocks until every submitted job has finished
+func (s *Scheduler) Wait() {
+	s.wg.Wait()
+}
+
+// Queued returns the keys of the jobs that are waiting, in the order they will start
+func (s *Scheduler) Queued() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, len(s.queue))
+	for i, job := range s.queue {
+		keys[i] = job.key
+	}
+	return keys
+}
+
+// IsRunning reports whether a job for key is running
+func (s *Scheduler) IsRunning(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.running[key]
+}
+
+// startJobs starts waiting jobs while there are free slots. Must be called with s.mu held.
+func (s *Scheduler) startJobs() {
+	for i := 0; i < len(s.queue) && len(s.running) < s.jobs; {
+		job := s.queue[i]
+		if s.running[job.key] {
+			// Wait for the running build of this package to finish first
+			i++
+			continue
+		}
+		s.queue = append(s.queue[:i], s.queue[i+1:]...)
+		delete(s.pending, job.key)
+		s.running[job.key] = true
+		if job.waited {
+			s.logger.Printf("Starting %s (%d waiting)", job.key, len(s.queue))
+		}
+		go s.runJob(job)
+	}
+}
+
+func (s *Scheduler) runJob(job *scheduledJob) {
+	defer s.wg.Done()
+	defer close(job.done)
+	defer func() {
+		s.mu.Lock()
+		delete(s.running, job.key)
+		s.startJobs()
+		s.mu.Unlock()
+	}()
+	job.run()
+}
@@ -0,0 +1,183 @@
+package helpers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ErrNoTerminal is returned when the fuzzy finder is needed but there is no terminal to show it in
+var ErrNoTerminal = errors.New("no terminal available to select packages, use --package, --all, --strategy, --frontend or --exclude")
+
+// selectionFileName is the file under StateDirName that remembers the last selection
+const selectionFileName = "selection.json"
+
+// SelectionShortcut is an entry at the top of the fuzzy finder that selects several packages at once
+type SelectionShortcut struct {
+	Label    string   // Shown in the finder, like "Last selection"
+	Packages []string // Package names or globs
+	Names    []string // Exact package names, like the saved last selection
+}
+
+// savedSelection is the contents of the selection file
+type savedSelection struct {
+	Packages []string  `json:"packages"`
+	SavedAt  time.Time `json:"savedAt"`
+}
+
+// LoadLastSelection returns the names of the packages selected last time in
+// the mediatool root. Returns nothing if no selection was saved yet.
+func LoadLastSelection(rootPath string) ([]string, error) {
+	data, err := os.ReadFile(filepath.Join(rootPath, StateDirName, selectionFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var saved savedSelection
+	if err := json.Unmarshal(data, &saved); err != nil {
+		// A broken file only costs selecting the packages again
+		return nil, nil
+	}
+	return saved.Packages, nil
+}
+
+// SaveLastSelection remembers the selected packages for LoadLastSelection
+func SaveLastSelection(rootPath string, packages []NodePackage) error {
+	saved := savedSelection{Packages: make([]string, 0, len(packages)), SavedAt: time.Now()}
+	for _, pkg := range packages {
+		saved.Packages = append(saved.Packages, pkg.PackageJson.Name)
+	}
+	return writeJSONFile(filepath.Join(rootPath, StateDirName, selectionFileName), saved)
+}
+
+// PackageSelector selects packages without the fuzzy finder
+type PackageSelector struct {
+	Packages   []string // Package names or globs, like @mediatool/* or editor
+	Names      []string // Exact package names, like the saved last selection
+	All        bool     // Start from every package instead of the named ones
+	Strategies []string // Keep only packages with one of these strategies
+	Frontend   bool     // Keep only frontend packages
+	Exclude    []string // Package names or globs to leave out
+}
+
+// IsSet reports whether any selector was given
+func (s PackageSelector) IsSet() bool {
+	return len(s.Packages) > 0 || len(s.Names) > 0 || s.All || len(s.Strategies) > 0 || s.Frontend || len(s.Exclude) > 0
+}
+
+// FilterPackages returns the packages matched by the selector. Packages named
+// with --package and by their exact names are combined, the other selectors
+// narrow the result down.
+func FilterPackages(packages []NodePackage, selector PackageSelector) ([]NodePackage, error) {
+	for _, pattern := range append(append([]string{}, selector.Packages...), selector.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid package pattern %q: %w", pattern, err)
+		}
+	}
+	for _, pattern := range selector.Packages {
+		if !anyPackageMatches(packages, pattern) {
+			return nil, fmt.Errorf("no package matches %q", pattern)
+		}
+	}
+	for _, name := range selector.Names {
+		if !slices.ContainsFunc(packages, func(pkg NodePackage) bool { return pkg.PackageJson.Name == name }) {
+			return nil, fmt.Errorf("no package is called %q", name)
+		}
+	}
+
+	selected := []NodePackage{}
+	for _, pkg := range packages {
+		name := pkg.PackageJson.Name
+		named := len(selector.Packages) > 0 || len(selector.Names) > 0
+		if named && !selector.All && !matchesAny(name, selector.Packages) && !slices.Contains(selector.Names, name) {
+			continue
+		}
+		if len(selector.Strategies) > 0 && !hasStrategy(pkg, selector.Strategies) {
+			continue
+		}
+		if selector.Frontend && !pkg.IsFrontend {
+			continue
+		}
+		if matchesAny(name, selector.Exclude) {
+			continue
+		}
+		selected = append(selected, pkg)
+	}
+
+	if len(selected) == 0 {
+		return nil, errors.New("no packages match the given selectors")
+	}
+	return selected, nil
+}
+
+// MatchPackageName reports whether a package name matches a name or glob.
+// Patterns are matched against the full name and against the name without
+// its scope, so "editor" matches "@mediatool/editor".
+func MatchPackageName(name string, pattern string) bool {
+	if name == pattern {
+		return true
+	}
+	if matched, _ := path.Match(pattern, name); matched {
+		return true
+	}
+	if index := strings.LastIndex(name, "/"); index != -1 && !strings.Contains(pattern, "/") {
+		matched, _ := path.Match(pattern, name[index+1:])
+		return matched
+	}
+	return false
+}
+
+// expandShortcuts returns the packages matched by the chosen shortcuts and
+// the chosen packages, each package once and in the order of packages. Only
+// the Packages of shortcuts are globs, chosen packages are exact names.
+func expandShortcuts(packages []NodePackage, shortcuts []SelectionShortcut, chosen []string) []NodePackage {
+	var patterns []string
+	names := slices.Clone(chosen)
+	for _, shortcut := range shortcuts {
+		patterns = append(patterns, shortcut.Packages...)
+		names = append(names, shortcut.Names...)
+	}
+	selected := []NodePackage{}
+	for _, pkg := range packages {
+		name := pkg.PackageJson.Name
+		if matchesAny(name, patterns) || slices.Contains(names, name) {
+			selected = append(selected, pkg)
+		}
+	}
+	return selected
+}
+
+func matchesAny(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if MatchPackageName(name, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+func anyPackageMatches(packages []NodePackage, pattern string) bool {
+	for _, pkg := range packages {
+		if MatchPackageName(pkg.PackageJson.Name, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasStrategy(pkg NodePackage, strategies []string) bool {
+	for _, strategy := range strategies {
+		if strings.EqualFold(string(pkg.Strategy), strategy) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,239 @@
+package helpers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sessionLockFileName is the file under StateDirName that marks a running watch session
+const sessionLockFileName = "session.lock"
+
+// joinedLockPattern matches the files under StateDirName of sessions that
+// build the packages the running session doesn't watch, named after their pid
+const joinedLockPattern = "session-*.lock"
+
+// SessionLock marks the mediatool root as watched by a running mtcli watch,
+// so a second one doesn't build the same packages into the same webapp
+type SessionLock struct {
+	PID       int       `json:"pid"`
+	Port      int       `json:"port"`           // Port of the session's log server, 0 if it has none
+	Bind      string    `json:"bind,omitempty"` // Address the log server listens on, all interfaces when empty
+	Packages  []string  `json:"packages"`       // Names of the watched packages
+	StartedAt time.Time `json:"startedAt"`
+
+	path string
+}
+
+// SessionLockedError is returned by AcquireSessionLock while another session holds the lock
+type SessionLockedError struct {
+	Lock *SessionLock
+}
+
+func (e *SessionLockedError) Error() string {
+	return fmt.Sprintf("mtcli watch is already running for this folder (pid %d)", e.Lock.PID)
+}
+
+// AcquireSessionLock creates the lock file of the mediatool root. A lock left
+// behind by a session that no longer runs is replaced. Returns a
+// *SessionLockedError if another session is running.
+func AcquireSessionLock(rootPath string) (*SessionLock, error) {
+	lock := &SessionLock{
+		PID:       os.Getpid(),
+		Packages:  []string{},
+		StartedAt: time.Now(),
+		path:      filepath.Join(rootPath, StateDirName, sessionLockFileName),
+	}
+	data, err := json.MarshalIndent(lock, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(lock.path), 0755); err != nil {
+		return nil, err
+	}
+
+	// The lock is written completely before it appears, so other sessions
+	// never read a partial one. Only one session can create it, a stale one
+	// is removed and created again.
+	tmp := siblingPath(lock.path, "tmp")
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp)
+	for attempt := 0; attempt < 2; attempt++ {
+		err := createFrom(tmp, lock.path, data)
+		if err == nil {
+			return lock, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+
+		existing, err := ReadSessionLock(rootPath)
+		if err != nil {
+			return nil, err
+		}
+		if existing != nil {
+			return nil, &SessionLockedError{Lock: existing}
+		}
+	}
+	return nil, fmt.Errorf("failed to create %s", lock.path)
+}
+
+// createFrom creates path with the contents of tmp, failing if path exists.
+// File systems without hard links get data written to a new file instead.
+func createFrom(tmp string, path string, data []byte) error {
+	err := os.Link(tmp, path)
+	if err == nil || os.IsExist(err) {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
+
+// JoinSessionLock records a session that builds the packages the running
+// session doesn't watch, in a file of its own next to the lock of the running
+// session. The packages saved to it are returned by WatchedByOtherSessions.
+func JoinSessionLock(rootPath string) (*SessionLock, error) {
+	pid := os.Getpid()
+	lock := &SessionLock{
+		PID:       pid,
+		Packages:  []string{},
+		StartedAt: time.Now(),
+		path:      filepath.Join(rootPath, StateDirName, strings.Replace(joinedLockPattern, "*", strconv.Itoa(pid), 1)),
+	}
+	if err := lock.Save(); err != nil {
+		return nil, err
+	}
+	return lock, nil
+}
+
+// WatchedByOtherSessions returns the packages watched by the sessions running
+// for the mediatool root other than this one, the running session and the
+// ones that joined it, keyed by package name with the pid of the session
+func WatchedByOtherSessions(rootPath string) (map[string]int, error) {
+	locks := []*SessionLock{}
+	running, err := ReadSessionLock(rootPath)
+	if err != nil {
+		return nil, err
+	}
+	if running != nil {
+		locks = append(locks, running)
+	}
+	paths, err := filepath.Glob(filepath.Join(rootPath, StateDirName, joinedLockPattern))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		lock, err := readLockFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if lock != nil {
+			locks = append(locks, lock)
+		}
+	}
+
+	watched := make(map[string]int)
+	for _, lock := range locks {
+		if lock.PID == os.Getpid() {
+			continue
+		}
+		for _, name := range lock.Packages {
+			watched[name] = lock.PID
+		}
+	}
+	return watched, nil
+}
+
+// ReadSessionLock returns the lock of the session running for the mediatool
+// root, or nil if none is running. Stale and broken lock files are removed.
+func ReadSessionLock(rootPath string) (*SessionLock, error) {
+	return readLockFile(filepath.Join(rootPath, StateDirName, sessionLockFileName))
+}
+
+// readLockFile returns the lock in the file at path, or nil if there is none
+// or its session no longer runs
+func readLockFile(path string) (*SessionLock, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	lock := &SessionLock{path: path}
+	if err := json.Unmarshal(data, lock); err != nil || lock.PID <= 0 || !processAlive(lock.PID) {
+		// Left behind by a session that crashed or was killed
+		if err := removeIfUnchanged(path, data); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	}
+	return lock, nil
+}
+
+// removeIfUnchanged removes the file at path if it still holds data. Another
+// session may have replaced a stale lock since it was read, and its lock must stay.
+func removeIfUnchanged(path string, data []byte) error {
+	current, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if !bytes.Equal(current, data) {
+		return nil
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Save writes the log server address and packages of the lock to its file
+func (l *SessionLock) Save() error {
+	if l == nil {
+		return nil
+	}
+	return writeJSONFile(l.path, l)
+}
+
+// Release removes the lock file, unless another session took it over
+func (l *SessionLock) Release() error {
+	if l == nil {
+		return nil
+	}
+	data, err := os.ReadFile(l.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	var current SessionLock
+	if err := json.Unmarshal(data, &current); err == nil && current.PID != l.PID {
+		return nil
+	}
+	if err := os.Remove(l.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,238 @@
+package helpers
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// StrategyDetector reports whether a strategy applies to the package at absolutePath
+type StrategyDetector func(folderItems map[string]bool, packageJson *PackageJson, absolutePath string) bool
+
+// StrategyCommandGenerator returns the default build config of a strategy for a package
+type StrategyCommandGenerator func(pkg NodePackage) BuildConfig
+
+// StrategyDefinition describes a linking strategy that can be registered
+type StrategyDefinition struct {
+	Name     LinkingStrategy
+	Priority int // Strategies with a higher priority are checked first
+	Detect   StrategyDetector
+	Generate StrategyCommandGenerator
+}
+
+// StrategyConfig declares a strategy in the root .mtcli.yaml
+type StrategyConfig struct {
+	Name        string         `yaml:"name"`
+	Priority    int            `yaml:"priority"`
+	Detect      StrategyDetect `yaml:"detect"`
+	BuildConfig `yaml:",inline"`
+}
+
+// StrategyDetect holds the rules of a declarative strategy. Every rule that is
+// set has to match, a list rule matches when any of its entries does.
+type StrategyDetect struct {
+	Files          []string `yaml:"files"`          // Files or folders in the package folder
+	Scripts        []string `yaml:"scripts"`        // Scripts in package.json
+	Dependencies   []string `yaml:"dependencies"`   // Any kind of dependency in package.json
+	PackageManager string   `yaml:"packageManager"` // Substring of the packageManager field
+}
+
+var (
+	strategies    = make(map[LinkingStrategy]StrategyDefinition)
+	strategiesMux sync.RWMutex
+)
+
+func init() {
+	rollupConfig := func(folderItems map[string]bool) bool {
+		return folderItems["rollup.config.mjs"] || folderItems["rollup.config.js"]
+	}
+	builtins := []StrategyDefinition{
+		{
+			Name:     TRANSPILED_YARN,
+			Priority: 500,
+			Detect: func(folderItems map[string]bool, packageJson *PackageJson, _ string) bool {
+				return rollupConfig(folderItems) && packageJson != nil &&
+					strings.Contains(packageJson.PackageManager, "yarn")
+			},
+			Generate: staticBuildConfig(BuildConfig{Commands: []string{"yarn transpile"}, Outputs: []string{"dist"}}),
+		},
+		{
+			Name:     TRANSPILED,
+			Priority: 400,
+			Detect: func(folderItems map[string]bool, _ *PackageJson, _ string) bool {
+				return rollupConfig(folderItems)
+			},
+			Generate: staticBuildConfig(BuildConfig{Commands: []string{"pnpm transpile"}, Outputs: []string{"dist"}}),
+		},
+		{
+			Name:     TRANSPILED_LEGACY,
+			Priority: 300,
+			Detect: func(_ map[string]bool, packageJson *PackageJson, _ string) bool {
+				if packageJson == nil || packageJson.Scripts == nil {
+					return false
+				}
+				_, hasBuild := packageJson.Scripts["build"]
+				return hasBuild
+			},
+			Generate: staticBuildConfig(BuildConfig{Commands: []string{"pnpm prepublishOnly"}, Outputs: []string{"dist"}}),
+		},
+		{
+			Name:     AMEND_NATIVE,
+			Priority: 200,
+			Detect: func(folderItems map[string]bool, _ *PackageJson, _ string) bool {
+				return folderItems["amend"] && folderItems["lib"]
+			},
+			Generate: func(pkg NodePackage) BuildConfig {
+				// Only copy folders if they exist
+				outputs := []string{}
+				for _, folder := range []string{"lib", "boundaries", "amend"} {
+					if pkg.FolderItems[folder] {
+						outputs = append(outputs, folder)
+					}
+				}
+				return BuildConfig{Commands: []string{}, Outputs: outputs}
+			},
+		},
+		{
+			Name:     MAKEFILE_BUILD,
+			Priority: 100,
+			Detect: func(folderItems map[string]bool, _ *PackageJson, _ string) bool {
+				return folderItems["Makefile"]
+			},
+			Generate: staticBuildConfig(BuildConfig{Commands: []string{"make build"}, Outputs: []string{}}),
+		},
+	}
+	for _, strategy := range builtins {
+		if err := RegisterStrategy(strategy); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// RegisterStrategy adds a strategy to the registry, replacing any strategy with the same name
+func RegisterStrategy(strategy StrategyDefinition) error {
+	if strategy.Name == "" || strategy.Name == UNKNOWN {
+		return errors.New("strategy needs a name")
+	}
+	if strategy.Detect == nil {
+		return fmt.Errorf("strategy %s needs a detector", strategy.Name)
+	}
+	if strategy.Generate == nil {
+		return fmt.Errorf("strategy %s needs a command generator", strategy.Name)
+	}
+
+	strategiesMux.Lock()
+	defer strategiesMux.Unlock()
+	strategies[strategy.Name] = strategy
+	return nil
+}
+
+// UnregisterStrategy removes a strategy from the registry
+func UnregisterStrategy(name LinkingStrategy) {
+	strategiesMux.Lock()
+	defer strategiesMux.Unlock()
+	delete(strategies, name)
+}
+
+// GetStrategy looks up a registered strategy by name
+func GetStrategy(name LinkingStrategy) (StrategyDefinition, bool) {
+	strategiesMux.RLock()
+	defer strategiesMux.RUnlock()
+	strategy, ok := strategies[name]
+	return strategy, ok
+}
+
+// RegisteredStrategies returns all registered strategies in priority order
+func RegisteredStrategies() []StrategyDefinition {
+	strategiesMux.RLock()
+	ordered := make([]StrategyDefinition, 0, len(strategies))
+	for _, strategy := range strategies {
+		ordered = append(ordered, strategy)
+	}
+	strategiesMux.RUnlock()
+
+	sort.Slice(ordered, func(i, j int) bool {
+		if ordered[i].Priority != ordered[j].Priority {
+			return ordered[i].Priority > ordered[j].Priority
+		}
+		return ordered[i].Name < ordered[j].Name
+	})
+	return ordered
+}
+
+// RegisterConfigStrategies registers the strategies declared in the root config
+func RegisterConfigStrategies(config *RootConfig) error {
+	if config == nil {
+		return nil
+	}
+	for _, strategyConfig := range config.Strategies {
+		if err := RegisterStrategy(strategyConfig.Definition()); err != nil {
+			return fmt.Errorf("invalid strategy in %s: %w", ConfigFileName, err)
+		}
+	}
+	return nil
+}
+
+// Definition turns a declared strategy into a registrable StrategyDefinition
+func (c StrategyConfig) Definition() StrategyDefinition {
+	detect := c.Detect
+	return StrategyDefinition{
+		Name:     LinkingStrategy(c.Name),
+		Priority: c.Priority,
+		Detect: func(folderItems map[string]bool, packageJson *PackageJson, _ string) bool {
+			return detect.matches(folderItems, packageJson)
+		},
+		Generate: staticBuildConfig(c.BuildConfig),
+	}
+}
+
+// matches checks the declared rules against a package
+func (d StrategyDetect) matches(folderItems map[string]bool, packageJson *PackageJson) bool {
+	if len(d.Files) == 0 && len(d.Scripts) == 0 && len(d.Dependencies) == 0 && d.PackageManager == "" {
+		// A strategy without rules would claim every package
+		return false
+	}
+	if len(d.Files) > 0 && !anyOf(d.Files, func(file string) bool { return folderItems[file] }) {
+		return false
+	}
+	if (len(d.Scripts) > 0 || len(d.Dependencies) > 0 || d.PackageManager != "") && packageJson == nil {
+		return false
+	}
+	if len(d.Scripts) > 0 && !anyOf(d.Scripts, func(script string) bool {
+		_, ok := packageJson.Scripts[script]
+		return ok
+	}) {
+		return false
+	}
+	if len(d.Dependencies) > 0 && !anyOf(d.Dependencies, packageJson.HasDependency) {
+		return false
+	}
+	if d.PackageManager != "" && !strings.Contains(packageJson.PackageManager, d.PackageManager) {
+		return false
+	}
+	return true
+}
+
+// staticBuildConfig returns a generator that always produces the same config
+func staticBuildConfig(config BuildConfig) StrategyCommandGenerator {
+	if config.Commands == nil {
+		config.Commands = []string{}
+	}
+	if config.Outputs == nil {
+		config.Outputs = []string{}
+	}
+	return func(NodePackage) BuildConfig {
+		return config
+	}
+}
+
+func anyOf(values []string, match func(string) bool) bool {
+	for _, value := range values {
+		if match(value) {
+			return true
+		}
+	}
+	return false
+}
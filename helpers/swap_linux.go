@@ -0,0 +1,8 @@
+package helpers
+
+import "golang.org/x/sys/unix"
+
+// exchangePaths swaps two existing paths in one step, so neither is ever missing
+func exchangePaths(a string, b string) error {
+	return unix.Renameat2(unix.AT_FDCWD, a, unix.AT_FDCWD, b, unix.RENAME_EXCHANGE)
+}
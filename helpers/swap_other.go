@@ -0,0 +1,10 @@
+//go:build !linux
+
+package helpers
+
+import "errors"
+
+// exchangePaths is only available on Linux, swapInto falls back to two renames elsewhere
+func exchangePaths(a string, b string) error {
+	return errors.New("exchanging paths is not supported on this platform")
+}
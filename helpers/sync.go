@@ -0,0 +1,326 @@
+package helpers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// SyncMode selects how files in the source and target are compared
+type SyncMode string
+
+const (
+	// SyncCompareMetadata treats files with the same size and modification time as equal
+	SyncCompareMetadata SyncMode = "mtime"
+	// SyncCompareHash compares the contents of files with the same size
+	SyncCompareHash SyncMode = "hash"
+)
+
+// SyncStats reports what a sync changed in the target
+type SyncStats struct {
+	Source    string `json:"source"`
+	Target    string `json:"target"`
+	Added     int    `json:"added"`
+	Changed   int    `json:"changed"`
+	Removed   int    `json:"removed"`
+	Unchanged int    `json:"unchanged"`
+}
+
+// String returns a short summary like "3 added, 1 changed, 0 removed"
+func (s SyncStats) String() string {
+	return fmt.Sprintf("%d added, %d changed, %d removed", s.Added, s.Changed, s.Removed)
+}
+
+// HasChanges reports whether the sync touched the target
+func (s SyncStats) HasChanges() bool {
+	return s.Added > 0 || s.Changed > 0 || s.Removed > 0
+}
+
+// syncEntry is a file, folder or symlink found while scanning a tree
+type syncEntry struct {
+	info fs.FileInfo
+	link string // Symlink destination
+}
+
+// SyncDir makes target an exact copy of source. Only files that differ are
+// copied, files missing from source are removed. The new tree is assembled
+// next to target and swapped in with a rename, so target is never half written.
+// When nothing differs target is left untouched.
+func SyncDir(ctx context.Context, source string, target string, mode SyncMode) (SyncStats, error) {
+	stats := SyncStats{Source: source, Target: target}
+
+	sourceInfo, err := os.Stat(source)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return stats, fmt.Errorf("output %s does not exist", source)
+		}
+		return stats, err
+	}
+	if !sourceInfo.IsDir() {
+		return syncFile(source, sourceInfo, target, mode)
+	}
+
+	sourceEntries, err := scanTree(source)
+	if err != nil {
+		return stats, err
+	}
+	targetEntries, err := scanTree(target)
+	if err != nil && !os.IsNotExist(err) {
+		return stats, err
+	}
+
+	// Work out which files have to be copied
+	unchanged := make(map[string]bool)
+	for rel, entry := range sourceEntries {
+		if entry.info.IsDir() {
+			continue
+		}
+		existing, ok := targetEntries[rel]
+		switch {
+		case !ok:
+			stats.Added++
+		case sameEntry(filepath.Join(source, rel), entry, filepath.Join(target, rel), existing, mode):
+			stats.Unchanged++
+			unchanged[rel] = true
+		default:
+			stats.Changed++
+		}
+	}
+	for rel, entry := range targetEntries {
+		if _, ok := sourceEntries[rel]; !ok && !entry.info.IsDir() {
+			stats.Removed++
+		}
+	}
+	if !stats.HasChanges() && sameFolders(sourceEntries, targetEntries) {
+		return stats, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return stats, err
+	}
+	staging := siblingPath(target, "sync")
+	if err := buildStagingTree(ctx, source, target, staging, sourceEntries, unchanged); err != nil {
+		os.RemoveAll(staging)
+		return stats, err
+	}
+	if err := swapInto(staging, target); err != nil {
+		os.RemoveAll(staging)
+		return stats, err
+	}
+	return stats, nil
+}
+
+// buildStagingTree writes the new tree into staging. Unchanged files are hard
+// linked from the current target, everything else is copied from source.
+func buildStagingTree(ctx context.Context, source string, target string, staging string, entries map[string]syncEntry, unchanged map[string]bool) error {
+	if err := os.Mkdir(staging, 0755); err != nil {
+		return err
+	}
+	for _, rel := range sortedKeys(entries) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		entry := entries[rel]
+		destination := filepath.Join(staging, rel)
+		switch {
+		case entry.info.IsDir():
+			if err := os.MkdirAll(destination, entry.info.Mode().Perm()|0700); err != nil {
+				return err
+			}
+		case entry.link != "":
+			if err := os.Symlink(entry.link, destination); err != nil {
+				return err
+			}
+		case unchanged[rel]:
+			if err := os.Link(filepath.Join(target, rel), destination); err != nil {
+				// Hard links are not available everywhere, fall back to a copy
+				if err := copyFile(filepath.Join(source, rel), destination, entry.info); err != nil {
+					return err
+				}
+			}
+		default:
+			if err := copyFile(filepath.Join(source, rel), destination, entry.info); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// syncFile handles a copy target that is a single file
+func syncFile(source string, sourceInfo fs.FileInfo, target string, mode SyncMode) (SyncStats, error) {
+	stats := SyncStats{Source: source, Target: target}
+	targetInfo, err := os.Lstat(target)
+	switch {
+	case os.IsNotExist(err):
+		stats.Added = 1
+	case err != nil:
+		return stats, err
+	case sameEntry(source, syncEntry{info: sourceInfo}, target, syncEntry{info: targetInfo}, mode):
+		stats.Unchanged = 1
+		return stats, nil
+	default:
+		stats.Changed = 1
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return stats, err
+	}
+	staging := siblingPath(target, "sync")
+	if err := copyFile(source, staging, sourceInfo); err != nil {
+		os.Remove(staging)
+		return stats, err
+	}
+	if err := os.Rename(staging, target); err != nil {
+		os.Remove(staging)
+		return stats, err
+	}
+	return stats, nil
+}
+
+// swapInto replaces target with staging. On Linux both are exchanged in one
+// step, so a dev server reading target never finds it missing. Where that is
+// not supported, target is moved aside first and is missing for a moment.
+func swapInto(staging string, target string) error {
+	if _, err := os.Lstat(target); os.IsNotExist(err) {
+		return os.Rename(staging, target)
+	}
+	if err := exchangePaths(staging, target); err == nil {
+		// staging now holds the previous tree
+		return os.RemoveAll(staging)
+	}
+
+	backup := siblingPath(target, "old")
+	if err := os.Rename(target, backup); err != nil {
+		return err
+	}
+	if err := os.Rename(staging, target); err != nil {
+		// Put the previous tree back so target is not left missing
+		if restoreErr := os.Rename(backup, target); restoreErr != nil {
+			return fmt.Errorf("%w, and restoring the previous %s failed, it is kept at %s: %v", err, target, backup, restoreErr)
+		}
+		return err
+	}
+	return os.RemoveAll(backup)
+}
+
+// scanTree lists every entry below root keyed by its path relative to root
+func scanTree(root string) (map[string]syncEntry, error) {
+	entries := make(map[string]syncEntry)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == root {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		entry := syncEntry{info: info}
+		if info.Mode()&os.ModeSymlink != 0 {
+			if entry.link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		entries[rel] = entry
+		return nil
+	})
+	return entries, err
+}
+
+// sameEntry reports whether two files can be treated as equal
+func sameEntry(sourcePath string, source syncEntry, targetPath string, target syncEntry, mode SyncMode) bool {
+	if source.link != "" || target.link != "" {
+		return source.link == target.link
+	}
+	if target.info.IsDir() || source.info.Size() != target.info.Size() {
+		return false
+	}
+	if mode == SyncCompareHash {
+		sourceHash, err := hashFile(sourcePath)
+		if err != nil {
+			return false
+		}
+		targetHash, err := hashFile(targetPath)
+		return err == nil && bytes.Equal(sourceHash, targetHash)
+	}
+	return source.info.ModTime().Equal(target.info.ModTime())
+}
+
+// sameFolders reports whether both trees contain the same folders
+func sameFolders(source map[string]syncEntry, target map[string]syncEntry) bool {
+	for rel, entry := range source {
+		if existing, ok := target[rel]; entry.info.IsDir() && (!ok || !existing.info.IsDir()) {
+			return false
+		}
+	}
+	for rel, entry := range target {
+		if _, ok := source[rel]; entry.info.IsDir() && !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// copyFile copies source to destination keeping its permissions and modification time
+func copyFile(source string, destination string, info fs.FileInfo) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(destination, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	// Keep the modification time so the next sync sees the file as unchanged
+	return os.Chtimes(destination, time.Now(), info.ModTime())
+}
+
+func hashFile(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
+}
+
+// siblingPath returns a unique temporary path next to path
+func siblingPath(path string, kind string) string {
+	suffix := strconv.Itoa(os.Getpid()) + "-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".mtcli-"+kind+"-"+suffix)
+}
+
+// sortedKeys returns the entries sorted so folders come before their contents
+func sortedKeys[V any](entries map[string]V) []string {
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
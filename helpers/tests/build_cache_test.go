@@ -0,0 +1,92 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/LajnaLegenden/transpiler4/helpers"
+)
+
+func TestHashPackage(t *testing.T) {
+	rootDir, err := os.MkdirTemp("", "build-cache-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(rootDir)
+
+	packageDir := filepath.Join(rootDir, "packages", "editor")
+	writeTestFile(t, filepath.Join(packageDir, "package.json"), `{"name": "editor"}`)
+	writeTestFile(t, filepath.Join(packageDir, "src", "index.ts"), "export const a = 1")
+	writeTestFile(t, filepath.Join(rootDir, "pnpm-lock.yaml"), "lockfileVersion: 9")
+
+	pkg := helpers.NodePackage{
+		Path:        packageDir,
+		RootPath:    rootDir,
+		PackageJson: &helpers.PackageJson{Name: "editor"},
+		Strategy:    helpers.TRANSPILED,
+	}
+
+	hash, err := helpers.HashPackage(pkg)
+	if err != nil {
+		t.Fatalf("Expected no error from HashPackage, got: %v", err)
+	}
+
+	// Build outputs are not part of the hash
+	writeTestFile(t, filepath.Join(packageDir, "dist", "index.js"), "built")
+	writeTestFile(t, filepath.Join(packageDir, "node_modules", "dep", "index.js"), "dep")
+	if again, _ := helpers.HashPackage(pkg); again != hash {
+		t.Errorf("Hash should not change when only build outputs change")
+	}
+
+	// Sources, the root lockfile and the strategy are
+	writeTestFile(t, filepath.Join(packageDir, "src", "index.ts"), "export const a = 2")
+	changed, _ := helpers.HashPackage(pkg)
+	if changed == hash {
+		t.Errorf("Hash should change when a source file changes")
+	}
+	writeTestFile(t, filepath.Join(rootDir, "pnpm-lock.yaml"), "lockfileVersion: 10")
+	if lockChanged, _ := helpers.HashPackage(pkg); lockChanged == changed {
+		t.Errorf("Hash should change when the root lockfile changes")
+	}
+	pkg.Strategy = helpers.TRANSPILED_LEGACY
+	if strategyChanged, _ := helpers.HashPackage(pkg); strategyChanged == changed {
+		t.Errorf("Hash should change when the strategy changes")
+	}
+}
+
+func TestBuildCacheStore(t *testing.T) {
+	rootDir, err := os.MkdirTemp("", "build-cache-store-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(rootDir)
+
+	cache, err := helpers.OpenBuildCache(rootDir)
+	if err != nil {
+		t.Fatalf("Expected no error opening an empty cache, got: %v", err)
+	}
+	if cache.Matches("editor", "abc") {
+		t.Errorf("Empty cache should not match")
+	}
+	if err := cache.Store("editor", "abc"); err != nil {
+		t.Fatalf("Expected no error storing cache entry, got: %v", err)
+	}
+
+	// The entry survives a restart
+	reopened, err := helpers.OpenBuildCache(rootDir)
+	if err != nil {
+		t.Fatalf("Expected no error reopening the cache, got: %v", err)
+	}
+	if !reopened.Matches("editor", "abc") || reopened.Matches("editor", "def") {
+		t.Errorf("Reopened cache should only match the stored hash")
+	}
+
+	// A rebuilt dependency changes the key of its dependents
+	consumer := helpers.NodePackage{PackageJson: &helpers.PackageJson{Name: "app", Dependencies: map[string]string{"editor": "*"}}}
+	before := reopened.WithDependencies(consumer, "xyz")
+	reopened.Store("editor", "def")
+	if reopened.WithDependencies(consumer, "xyz") == before {
+		t.Errorf("Key should change when a dependency is rebuilt")
+	}
+}
@@ -0,0 +1,154 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/LajnaLegenden/transpiler4/helpers"
+)
+
+func TestBuildConfigMerge(t *testing.T) {
+	base := helpers.BuildConfig{
+		Commands: []string{"pnpm transpile"},
+		Outputs:  []string{"dist"},
+		Env:      map[string]string{"NODE_ENV": "production", "CI": "1"},
+	}
+	override := helpers.BuildConfig{
+		Commands: []string{"pnpm build:dev"},
+		Env:      map[string]string{"NODE_ENV": "development"},
+	}
+
+	merged := base.Merge(override)
+	if !reflect.DeepEqual(merged.Commands, []string{"pnpm build:dev"}) {
+		t.Errorf("Expected commands to be overridden, got: %v", merged.Commands)
+	}
+	if !reflect.DeepEqual(merged.Outputs, []string{"dist"}) {
+		t.Errorf("Expected outputs to be kept, got: %v", merged.Outputs)
+	}
+	if merged.Env["NODE_ENV"] != "development" || merged.Env["CI"] != "1" {
+		t.Errorf("Expected env to be merged, got: %v", merged.Env)
+	}
+	if base.Env["NODE_ENV"] != "production" {
+		t.Errorf("Merge should not modify the base config")
+	}
+
+	// An explicitly empty list clears the default
+	merged = base.Merge(helpers.BuildConfig{Outputs: []string{}})
+	if merged.Outputs == nil || len(merged.Outputs) != 0 {
+		t.Errorf("Expected outputs to be cleared, got: %v", merged.Outputs)
+	}
+}
+
+func TestFindNodePackagesLoadsConfig(t *testing.T) {
+	rootDir, err := os.MkdirTemp("", "build-config-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(rootDir)
+
+	rootConfig := `
+build:
+  env:
+    FORCE_COLOR: "1"
+packages:
+  yaml-package:
+    outputs: [dist, types]
+`
+	if err := os.WriteFile(filepath.Join(rootDir, helpers.ConfigFileName), []byte(rootConfig), 0644); err != nil {
+		t.Fatalf("Failed to write root config: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(rootDir, "package.json"), []byte(`{"name": "@mediatool/root"}`), 0644); err != nil {
+		t.Fatalf("Failed to write root package.json: %v", err)
+	}
+
+	// Package configured through .mtcli.yaml
+	yamlDir := filepath.Join(rootDir, "packages", "yaml-package")
+	if err := os.MkdirAll(yamlDir, 0755); err != nil {
+		t.Fatalf("Failed to create package directory: %v", err)
+	}
+	os.WriteFile(filepath.Join(yamlDir, "package.json"), []byte(`{"name": "yaml-package"}`), 0644)
+	os.WriteFile(filepath.Join(yamlDir, "rollup.config.mjs"), []byte(""), 0644)
+	os.WriteFile(filepath.Join(yamlDir, helpers.ConfigFileName), []byte("commands: [\"pnpm build:watch\"]\n"), 0644)
+
+	// Package configured through the mtcli key in package.json
+	jsonDir := filepath.Join(rootDir, "packages", "json-package")
+	if err := os.MkdirAll(jsonDir, 0755); err != nil {
+		t.Fatalf("Failed to create package directory: %v", err)
+	}
+	os.WriteFile(filepath.Join(jsonDir, "package.json"), []byte(`{
+		"name": "json-package",
+		"mtcli": {"copy": [{"from": "styles", "to": "css"}], "env": {"FORCE_COLOR": "0"}}
+	}`), 0644)
+	os.WriteFile(filepath.Join(jsonDir, "Makefile"), []byte(""), 0644)
+
+	packages, err := helpers.FindNodePackages(rootDir)
+	if err != nil {
+		t.Fatalf("Expected no error from FindNodePackages, got: %v", err)
+	}
+
+	configs := map[string]helpers.BuildConfig{}
+	for _, pkg := range packages {
+		configs[pkg.PackageJson.Name] = helpers.GetBuildConfig(pkg)
+	}
+
+	yamlConfig := configs["yaml-package"]
+	if !reflect.DeepEqual(yamlConfig.Commands, []string{"pnpm build:watch"}) {
+		t.Errorf("Expected commands from .mtcli.yaml, got: %v", yamlConfig.Commands)
+	}
+	if !reflect.DeepEqual(yamlConfig.Outputs, []string{"dist", "types"}) {
+		t.Errorf("Expected outputs from the root config, got: %v", yamlConfig.Outputs)
+	}
+	if yamlConfig.Env["FORCE_COLOR"] != "1" {
+		t.Errorf("Expected env from the root config, got: %v", yamlConfig.Env)
+	}
+
+	jsonConfig := configs["json-package"]
+	if !reflect.DeepEqual(jsonConfig.Commands, []string{"make build"}) {
+		t.Errorf("Expected strategy default commands, got: %v", jsonConfig.Commands)
+	}
+	if len(jsonConfig.Copy) != 1 || jsonConfig.Copy[0].To != "css" {
+		t.Errorf("Expected copy target from package.json, got: %v", jsonConfig.Copy)
+	}
+	if jsonConfig.Env["FORCE_COLOR"] != "0" {
+		t.Errorf("Expected package env to override the root env, got: %v", jsonConfig.Env)
+	}
+}
+
+func TestBuildConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  helpers.BuildConfig
+		invalid bool
+	}{
+		{"outputs and copy", helpers.BuildConfig{Outputs: []string{"dist", "lib/types"}, Copy: []helpers.CopyTarget{{From: "styles", To: "css"}}}, false},
+		{"empty copy target", helpers.BuildConfig{Copy: []helpers.CopyTarget{{From: "styles", To: ""}}}, true},
+		{"copy into the package folder", helpers.BuildConfig{Copy: []helpers.CopyTarget{{From: "styles", To: "."}}}, true},
+		{"copy out of node_modules", helpers.BuildConfig{Copy: []helpers.CopyTarget{{From: "styles", To: "../.."}}}, true},
+		{"absolute source", helpers.BuildConfig{Copy: []helpers.CopyTarget{{From: "/etc", To: "etc"}}}, true},
+		{"output leaving the package", helpers.BuildConfig{Outputs: []string{"dist/../../other"}}, true},
+		{"package folder as output", helpers.BuildConfig{Outputs: []string{"./"}}, true},
+	}
+	for _, test := range tests {
+		err := test.config.Validate()
+		if test.invalid && err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		} else if !test.invalid && err != nil {
+			t.Errorf("%s: expected no error, got: %v", test.name, err)
+		}
+	}
+
+	// Invalid paths are rejected when the config is loaded
+	rootDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(rootDir, helpers.ConfigFileName), []byte("build:\n  copy: [{from: dist, to: ..}]\n"), 0644); err != nil {
+		t.Fatalf("Failed to write root config: %v", err)
+	}
+	if _, err := helpers.LoadRootConfig(rootDir); err == nil {
+		t.Error("Expected an error loading a root config copying out of node_modules")
+	}
+	packageJson := &helpers.PackageJson{Name: "ui", Mtcli: &helpers.BuildConfig{Outputs: []string{""}}}
+	if _, err := helpers.LoadPackageConfig(rootDir+"/missing", packageJson); err == nil {
+		t.Error("Expected an error loading an empty output from package.json")
+	}
+}
@@ -0,0 +1,133 @@
+//go:build !windows
+
+package tests
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/LajnaLegenden/transpiler4/helpers"
+)
+
+func TestBuildPackageResult(t *testing.T) {
+	rootDir, err := os.MkdirTemp("", "build-package-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(rootDir)
+	helpers.SetBuildCacheEnabled(false)
+	defer helpers.SetBuildCacheEnabled(true)
+
+	packageDir := filepath.Join(rootDir, "packages", "editor")
+	webappDir := filepath.Join(rootDir, "webapp")
+	writeTestFile(t, filepath.Join(packageDir, "package.json"), `{"name": "@mediatool/editor"}`)
+
+	pkg := helpers.NodePackage{
+		Path:        packageDir,
+		RootPath:    rootDir,
+		PackageJson: &helpers.PackageJson{Name: "@mediatool/editor"},
+		Strategy:    helpers.TRANSPILED,
+		Config: &helpers.BuildConfig{
+			Commands: []string{"mkdir -p dist && echo built > dist/index.js", "echo warning >&2"},
+		},
+	}
+
+	result, err := helpers.BuildPackage(context.Background(), pkg, webappDir)
+	if err != nil {
+		t.Fatalf("Expected no error from BuildPackage, got: %v", err)
+	}
+	if !result.Success || result.Package != "@mediatool/editor" || result.Strategy != helpers.TRANSPILED {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+	if len(result.Steps) != 2 || result.Steps[0].ExitCode != 0 {
+		t.Fatalf("Expected 2 successful steps, got: %+v", result.Steps)
+	}
+	if tail := result.Steps[1].StderrTail; len(tail) != 1 || tail[0] != "warning" {
+		t.Errorf("Expected stderr tail [warning], got: %v", tail)
+	}
+	if len(result.Artifacts) != 1 || result.Artifacts[0].Added != 1 {
+		t.Errorf("Expected one copied file, got: %+v", result.Artifacts)
+	}
+	if _, err := os.Stat(filepath.Join(webappDir, "node_modules", "@mediatool", "editor", "dist", "index.js")); err != nil {
+		t.Errorf("Expected dist to be copied into the webapp: %v", err)
+	}
+
+	// A failing command stops the build and is reported with its exit code
+	pkg.Config = &helpers.BuildConfig{Commands: []string{"echo broken >&2; exit 2", "echo never"}}
+	result, err = helpers.BuildPackage(context.Background(), pkg, webappDir)
+	if err == nil {
+		t.Fatalf("Expected an error from a failing build")
+	}
+	if result.Success || result.FailedStep != "echo broken >&2; exit 2" {
+		t.Errorf("Expected failed step to be reported, got: %+v", result)
+	}
+	if len(result.Steps) != 1 || result.Steps[0].ExitCode != 2 {
+		t.Errorf("Expected one step with exit code 2, got: %+v", result.Steps)
+	}
+}
+
+func TestBuildPackageCache(t *testing.T) {
+	rootDir := t.TempDir()
+	packageDir := filepath.Join(rootDir, "packages", "editor")
+	webappDir := filepath.Join(rootDir, "webapp")
+	runsPath := filepath.Join(rootDir, "runs.log")
+	writeTestFile(t, filepath.Join(packageDir, "package.json"), `{"name": "@mediatool/editor"}`)
+	writeTestFile(t, filepath.Join(packageDir, "index.ts"), "export {}")
+
+	pkg := helpers.NodePackage{
+		Path:        packageDir,
+		RootPath:    rootDir,
+		PackageJson: &helpers.PackageJson{Name: "@mediatool/editor"},
+		Strategy:    helpers.TRANSPILED,
+		Config: &helpers.BuildConfig{
+			// Writes dist before it fails when the fail file exists
+			Commands: []string{"echo run >> " + runsPath + " && mkdir -p dist && echo broken > dist/index.js && test ! -f fail && echo built > dist/index.js"},
+			Outputs:  []string{"dist"},
+		},
+	}
+	build := func() *helpers.BuildResult {
+		result, _ := helpers.BuildPackage(context.Background(), pkg, webappDir)
+		return result
+	}
+	runs := func() int {
+		data, _ := os.ReadFile(runsPath)
+		return len(data) / len("run\n")
+	}
+	copied := filepath.Join(webappDir, "node_modules", "@mediatool", "editor", "dist", "index.js")
+
+	if result := build(); !result.Success || result.Cached {
+		t.Fatalf("Expected the first build to run its commands, got: %+v", result)
+	}
+	if result := build(); !result.Cached || runs() != 1 {
+		t.Errorf("Expected an unchanged package to skip its commands, ran them %d times", runs())
+	}
+
+	// The copy in the webapp was deleted
+	os.RemoveAll(filepath.Dir(copied))
+	if result := build(); result.Cached || runs() != 2 {
+		t.Errorf("Expected the commands to run when the webapp copy is missing, ran them %d times", runs())
+	}
+
+	// A failed build must not let the previous hash skip the commands once the sources are reverted
+	writeTestFile(t, filepath.Join(packageDir, "fail"), "")
+	if result := build(); result.Success {
+		t.Fatalf("Expected the build to fail")
+	}
+	os.Remove(filepath.Join(packageDir, "fail"))
+	if result := build(); !result.Success || result.Cached || runs() != 4 {
+		t.Errorf("Expected the commands to run after a failed build, ran them %d times", runs())
+	}
+	if data, _ := os.ReadFile(copied); string(data) != "built\n" {
+		t.Errorf("Expected the webapp to get the rebuilt output, got %q", data)
+	}
+
+	// A package that copies nothing always runs its commands
+	pkg.Config = &helpers.BuildConfig{Commands: []string{"echo run >> " + runsPath}, Outputs: []string{}}
+	build()
+	build()
+	if runs() != 6 {
+		t.Errorf("Expected a package without outputs to run its commands on every build, ran them %d times", runs())
+	}
+}
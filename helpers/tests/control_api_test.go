@@ -0,0 +1,245 @@
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/LajnaLegenden/transpiler4/helpers"
+	"github.com/LajnaLegenden/transpiler4/logsocket"
+)
+
+// fakeController watches @mediatool/editor and @mediatool/ui and records the
+// commands it receives. Pausing a paused package fails.
+type fakeController struct {
+	mu       sync.Mutex
+	paused   map[string]bool
+	commands []string
+}
+
+func newFakeController() *fakeController {
+	return &fakeController{paused: map[string]bool{"@mediatool/editor": true}}
+}
+
+func (c *fakeController) Packages() []logsocket.PackageState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return []logsocket.PackageState{
+		{Name: "@mediatool/editor", Status: "idle", Paused: c.paused["@mediatool/editor"]},
+		{Name: "@mediatool/ui", Status: "idle", Paused: c.paused["@mediatool/ui"]},
+	}
+}
+
+func (c *fakeController) run(command string, name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if name != "@mediatool/editor" && name != "@mediatool/ui" {
+		return fmt.Errorf("%w %s", logsocket.ErrUnknownPackage, name)
+	}
+	c.commands = append(c.commands, command+" "+name)
+	return nil
+}
+
+func (c *fakeController) Rebuild(name string) error { return c.run("rebuild", name) }
+func (c *fakeController) Resume(name string) error  { return c.run("resume", name) }
+func (c *fakeController) Cancel(name string) error  { return c.run("cancel", name) }
+
+func (c *fakeController) Pause(name string) error {
+	c.mu.Lock()
+	paused := c.paused[name]
+	c.mu.Unlock()
+	if paused {
+		return errors.New(name + " is already paused")
+	}
+	return c.run("pause", name)
+}
+
+func (c *fakeController) Add(pattern string) ([]string, error) {
+	return nil, errors.New("no package matches " + pattern)
+}
+
+func (c *fakeController) Remove(pattern string) ([]string, error) {
+	return nil, errors.New("no package matches " + pattern)
+}
+
+func (c *fakeController) Available() []helpers.NodePackage { return nil }
+func (c *fakeController) Stop()                            {}
+
+// startControlServer starts the server on a free port and returns its URL
+func startControlServer(t *testing.T) string {
+	port, err := logsocket.StartServer("127.0.0.1", 0)
+	if err != nil {
+		t.Fatalf("Failed to start the server: %v", err)
+	}
+	t.Cleanup(func() { logsocket.StopServer() })
+	return logsocket.ServerURL("127.0.0.1", port)
+}
+
+func TestControlAPIRejectsOtherPages(t *testing.T) {
+	serverURL := startControlServer(t)
+	rebound := "rebound.example.com:" + serverURL[strings.LastIndex(serverURL, ":")+1:]
+
+	tests := []struct {
+		name        string
+		method      string
+		host        string // Sent instead of the address of the server when set
+		origin      string
+		contentType string
+		expected    int
+	}{
+		// No watch session is running, so allowed requests get a 503
+		{"script reading packages", http.MethodGet, "", "", "", http.StatusServiceUnavailable},
+		{"script stopping the session", http.MethodPost, "", "", "application/json", http.StatusServiceUnavailable},
+		{"log viewer stopping the session", http.MethodPost, "", serverURL, "application/json; charset=utf-8", http.StatusServiceUnavailable},
+		{"other page reading packages", http.MethodGet, "", "http://example.com", "", http.StatusForbidden},
+		{"other page stopping the session", http.MethodPost, "", "http://example.com", "application/json", http.StatusForbidden},
+		{"form stopping the session", http.MethodPost, "", "", "application/x-www-form-urlencoded", http.StatusUnsupportedMediaType},
+		{"stop without a body", http.MethodPost, "", "", "", http.StatusUnsupportedMediaType},
+		// DNS rebinding, a page of another host name resolving to this machine
+		{"rebound page reading packages", http.MethodGet, rebound, "http://" + rebound, "", http.StatusForbidden},
+		{"rebound page stopping the session", http.MethodPost, rebound, "http://" + rebound, "application/json", http.StatusForbidden},
+		{"script using localhost", http.MethodGet, "localhost", "", "", http.StatusServiceUnavailable},
+	}
+	for _, test := range tests {
+		path := "/api/packages"
+		if test.method == http.MethodPost {
+			path = "/api/stop"
+		}
+		request, err := http.NewRequest(test.method, serverURL+path, strings.NewReader("null"))
+		if err != nil {
+			t.Fatalf("%s: failed to create request: %v", test.name, err)
+		}
+		if test.host != "" {
+			request.Host = test.host
+		}
+		if test.origin != "" {
+			request.Header.Set("Origin", test.origin)
+		}
+		if test.contentType != "" {
+			request.Header.Set("Content-Type", test.contentType)
+		}
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatalf("%s: request failed: %v", test.name, err)
+		}
+		response.Body.Close()
+		if response.StatusCode != test.expected {
+			t.Errorf("%s: expected status %d, got %d", test.name, test.expected, response.StatusCode)
+		}
+	}
+
+	// WebSocket connections from other pages are refused as well
+	wsURL := "ws" + strings.TrimPrefix(serverURL, "http") + "/ws"
+	if conn, _, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"Origin": {"http://example.com"}}); err == nil {
+		conn.Close()
+		t.Error("Expected a WebSocket connection from another origin to be refused")
+	}
+	if conn, _, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"Origin": {"http://" + rebound}, "Host": {rebound}}); err == nil {
+		conn.Close()
+		t.Error("Expected a WebSocket connection from a rebound host name to be refused")
+	}
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"Origin": {serverURL}})
+	if err != nil {
+		t.Fatalf("Expected the log viewer to connect, got: %v", err)
+	}
+	conn.Close()
+}
+
+func TestControlAPIPackageRoutes(t *testing.T) {
+	serverURL := startControlServer(t)
+	controller := newFakeController()
+	logsocket.SetController(controller)
+	defer logsocket.SetController(nil)
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		expected int
+		pkg      string // Name of the package in the response
+	}{
+		{"scoped package", http.MethodPost, "/api/packages/@mediatool/ui/rebuild", http.StatusOK, "@mediatool/ui"},
+		{"other scoped package", http.MethodPost, "/api/packages/@mediatool/editor/resume", http.StatusOK, "@mediatool/editor"},
+		{"show one package", http.MethodGet, "/api/packages/@mediatool/ui", http.StatusOK, "@mediatool/ui"},
+		{"unknown package", http.MethodPost, "/api/packages/@mediatool/missing/rebuild", http.StatusNotFound, ""},
+		{"show unknown package", http.MethodGet, "/api/packages/@mediatool/missing", http.StatusNotFound, ""},
+		{"unknown action", http.MethodPost, "/api/packages/@mediatool/ui/explode", http.StatusNotFound, ""},
+		// Listing would answer with the first package instead of the named one
+		{"listing packages", http.MethodPost, "/api/packages/@mediatool/ui/packages", http.StatusNotFound, ""},
+		{"adding through the package route", http.MethodPost, "/api/packages/@mediatool/ui/add", http.StatusNotFound, ""},
+		{"no action", http.MethodPost, "/api/packages/ui", http.StatusNotFound, ""},
+		{"command failing", http.MethodPost, "/api/packages/@mediatool/editor/pause", http.StatusConflict, ""},
+	}
+	for _, test := range tests {
+		request, err := http.NewRequest(test.method, serverURL+test.path, nil)
+		if err != nil {
+			t.Fatalf("%s: failed to create request: %v", test.name, err)
+		}
+		request.Header.Set("Content-Type", "application/json")
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatalf("%s: request failed: %v", test.name, err)
+		}
+		var state logsocket.PackageState
+		json.NewDecoder(response.Body).Decode(&state)
+		response.Body.Close()
+		if response.StatusCode != test.expected {
+			t.Errorf("%s: expected status %d, got %d", test.name, test.expected, response.StatusCode)
+		}
+		if test.pkg != "" && state.Name != test.pkg {
+			t.Errorf("%s: expected %s in the response, got %q", test.name, test.pkg, state.Name)
+		}
+	}
+
+	expected := []string{"rebuild @mediatool/ui", "resume @mediatool/editor"}
+	if fmt.Sprint(controller.commands) != fmt.Sprint(expected) {
+		t.Errorf("Expected the controller to receive %q, got %q", expected, controller.commands)
+	}
+}
+
+func TestControlAPIWebSocketCommands(t *testing.T) {
+	serverURL := startControlServer(t)
+	logsocket.SetController(newFakeController())
+	defer logsocket.SetController(nil)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(serverURL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	tests := []struct {
+		command string
+		id      string // Raw JSON
+		ok      bool
+	}{
+		{`{"id": 1, "command": "rebuild", "package": "@mediatool/ui"}`, "1", true},
+		{`{"id": "pause-editor", "command": "pause", "package": "@mediatool/editor"}`, `"pause-editor"`, false},
+		{`{"id": {"n": 3}, "command": "packages"}`, `{"n":3}`, true},
+		{`{"command": "explode"}`, "", false},
+	}
+	for _, test := range tests {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(test.command)); err != nil {
+			t.Fatalf("Failed to send %s: %v", test.command, err)
+		}
+		// Log messages may arrive before the response
+		var response logsocket.CommandResponse
+		for response.Type != "response" {
+			if err := conn.ReadJSON(&response); err != nil {
+				t.Fatalf("Failed to read the response to %s: %v", test.command, err)
+			}
+		}
+		if id := strings.ReplaceAll(string(response.ID), " ", ""); id != test.id {
+			t.Errorf("%s: expected the id %s to be sent back, got %s", test.command, test.id, id)
+		}
+		if response.OK != test.ok {
+			t.Errorf("%s: expected ok to be %v, got %+v", test.command, test.ok, response)
+		}
+	}
+}
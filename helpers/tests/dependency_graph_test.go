@@ -0,0 +1,161 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/LajnaLegenden/transpiler4/helpers"
+)
+
+func newGraphPackage(name string, dependencies ...string) helpers.NodePackage {
+	packageJson := &helpers.PackageJson{Name: name, Dependencies: map[string]string{}}
+	for _, dependency := range dependencies {
+		packageJson.Dependencies[dependency] = "workspace:*"
+	}
+	return helpers.NodePackage{Path: "/packages/" + name, PackageJson: packageJson}
+}
+
+func TestDependencyGraphOrder(t *testing.T) {
+	packages := []helpers.NodePackage{
+		newGraphPackage("editor", "ui", "media-types", "react"),
+		newGraphPackage("ui", "media-types"),
+		newGraphPackage("media-types"),
+		newGraphPackage("standalone"),
+	}
+
+	ordered, err := helpers.NewDependencyGraph(packages).Order()
+	if err != nil {
+		t.Fatalf("Expected no error from Order, got: %v", err)
+	}
+
+	position := map[string]int{}
+	for i, pkg := range ordered {
+		position[pkg.PackageJson.Name] = i
+	}
+	if len(position) != 4 {
+		t.Fatalf("Expected 4 packages in order, got: %d", len(position))
+	}
+	if position["media-types"] > position["ui"] || position["ui"] > position["editor"] {
+		t.Errorf("Dependencies should come before dependents, got order: %v", position)
+	}
+}
+
+func TestDependencyGraphCycle(t *testing.T) {
+	packages := []helpers.NodePackage{
+		newGraphPackage("a", "b"),
+		newGraphPackage("b", "c"),
+		newGraphPackage("c", "a"),
+	}
+
+	graph := helpers.NewDependencyGraph(packages)
+	if _, err := graph.Order(); err == nil || !strings.Contains(err.Error(), "a -> b -> c -> a") {
+		t.Errorf("Expected a cycle error naming the packages, got: %v", err)
+	}
+	if _, err := graph.Run(context.Background(), func(context.Context, helpers.NodePackage) error { return nil }); err == nil {
+		t.Errorf("Expected Run to refuse a graph with a cycle")
+	}
+}
+
+func TestDependencyGraphSubgraph(t *testing.T) {
+	// editor depends on media-types through ui, which is not selected
+	packages := []helpers.NodePackage{
+		newGraphPackage("editor", "ui"),
+		newGraphPackage("ui", "media-types"),
+		newGraphPackage("media-types"),
+	}
+	selected := []helpers.NodePackage{packages[0], packages[2]}
+
+	subgraph := helpers.NewDependencyGraph(packages).Subgraph(selected)
+	if deps := subgraph.Dependencies("editor"); len(deps) != 1 || deps[0] != "media-types" {
+		t.Errorf("Expected editor to depend on media-types through ui, got: %v", deps)
+	}
+	if len(subgraph.Packages()) != 2 {
+		t.Errorf("Expected only the selected packages in the subgraph, got: %d", len(subgraph.Packages()))
+	}
+}
+
+func TestDependencyGraphRun(t *testing.T) {
+	packages := []helpers.NodePackage{
+		newGraphPackage("editor", "ui"),
+		newGraphPackage("ui", "media-types"),
+		newGraphPackage("media-types"),
+		newGraphPackage("broken"),
+		newGraphPackage("needs-broken", "broken"),
+	}
+
+	var mu sync.Mutex
+	var built []string
+	failures, err := helpers.NewDependencyGraph(packages).Run(context.Background(), func(ctx context.Context, pkg helpers.NodePackage) error {
+		if pkg.PackageJson.Name == "broken" {
+			return errors.New("build failed")
+		}
+		mu.Lock()
+		built = append(built, pkg.PackageJson.Name)
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Expected no error from Run, got: %v", err)
+	}
+
+	position := map[string]int{}
+	for i, name := range built {
+		position[name] = i
+	}
+	if position["media-types"] > position["ui"] || position["ui"] > position["editor"] {
+		t.Errorf("Dependencies should be built before dependents, got: %v", built)
+	}
+	if _, ok := position["needs-broken"]; ok {
+		t.Errorf("Package depending on a failed package should be skipped")
+	}
+	if len(failures) != 2 || failures["broken"] == nil || failures["needs-broken"] == nil {
+		t.Errorf("Expected broken and needs-broken to be reported, got: %v", failures)
+	}
+}
+
+func TestDependencyGraphRunCancelled(t *testing.T) {
+	packages := []helpers.NodePackage{
+		newGraphPackage("ui", "media-types"),
+		newGraphPackage("media-types"),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var built []string
+	failures, err := helpers.NewDependencyGraph(packages).Run(ctx, func(ctx context.Context, pkg helpers.NodePackage) error {
+		// Another build failed and cancelled the rest, like --fail-fast
+		built = append(built, pkg.PackageJson.Name)
+		cancel()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Expected no error from Run, got: %v", err)
+	}
+	if len(built) != 1 || built[0] != "media-types" {
+		t.Errorf("Expected only media-types to be built, got: %v", built)
+	}
+	if !errors.Is(failures["ui"], context.Canceled) {
+		t.Errorf("Expected ui to be skipped because of the cancel, got: %v", failures["ui"])
+	}
+}
+
+func TestDependencyGraphRunDependencyCancelled(t *testing.T) {
+	packages := []helpers.NodePackage{
+		newGraphPackage("ui", "media-types"),
+		newGraphPackage("media-types"),
+	}
+
+	failures, err := helpers.NewDependencyGraph(packages).Run(context.Background(), func(ctx context.Context, pkg helpers.NodePackage) error {
+		// The build of media-types was cancelled while it ran
+		return fmt.Errorf("failed to copy dist: %w", context.Canceled)
+	})
+	if err != nil {
+		t.Fatalf("Expected no error from Run, got: %v", err)
+	}
+	if !errors.Is(failures["ui"], context.Canceled) || strings.Contains(failures["ui"].Error(), "dependency") {
+		t.Errorf("Expected ui to be skipped because of the cancel instead of a failed dependency, got: %v", failures["ui"])
+	}
+}
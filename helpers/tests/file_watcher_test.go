@@ -0,0 +1,140 @@
+package tests
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/LajnaLegenden/transpiler4/helpers"
+)
+
+// waitForEvent returns the first event for path, failing the test if none arrives
+func waitForEvent(t *testing.T, events <-chan helpers.WatchEvent, path string) helpers.WatchEvent {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event := <-events:
+			if event.Path == path {
+				return event
+			}
+		case <-timeout:
+			t.Fatalf("No event for %s", path)
+		}
+	}
+}
+
+// expectNoEvent fails the test if any event arrives within a short time
+func expectNoEvent(t *testing.T, events <-chan helpers.WatchEvent) {
+	t.Helper()
+	select {
+	case event := <-events:
+		t.Errorf("Expected no event, got: %+v", event)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+// drainEvents reads events until none arrive for a short time. Writing a file can cause several events.
+func drainEvents(events <-chan helpers.WatchEvent) {
+	for {
+		select {
+		case <-events:
+		case <-time.After(100 * time.Millisecond):
+			return
+		}
+	}
+}
+
+func newTestFileWatcher(t *testing.T) *helpers.FileWatcher {
+	watcher, err := helpers.NewFileWatcher(log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	t.Cleanup(func() { watcher.Close() })
+	return watcher
+}
+
+func TestFileWatcherRoutesToPackage(t *testing.T) {
+	root := t.TempDir()
+	editor := filepath.Join(root, "editor")
+	// A package nested in the folder of another package
+	ui := filepath.Join(editor, "packages", "ui")
+	writeTestFile(t, filepath.Join(editor, "src", "index.ts"), "")
+	writeTestFile(t, filepath.Join(ui, "src", "index.ts"), "")
+
+	watcher := newTestFileWatcher(t)
+	editorEvents, err := watcher.Add(editor, nil)
+	if err != nil {
+		t.Fatalf("Failed to watch editor: %v", err)
+	}
+	uiEvents, err := watcher.Add(ui, nil)
+	if err != nil {
+		t.Fatalf("Failed to watch ui: %v", err)
+	}
+
+	writeTestFile(t, filepath.Join(ui, "src", "index.ts"), "changed")
+	if event := waitForEvent(t, uiEvents, filepath.Join(ui, "src", "index.ts")); !event.Has(helpers.WatchWrite) {
+		t.Errorf("Expected a write event, got: %+v", event)
+	}
+	drainEvents(uiEvents)
+	expectNoEvent(t, editorEvents)
+
+	writeTestFile(t, filepath.Join(editor, "src", "index.ts"), "changed")
+	waitForEvent(t, editorEvents, filepath.Join(editor, "src", "index.ts"))
+	drainEvents(editorEvents)
+	drainEvents(uiEvents)
+
+	// Once ui is removed, its folder belongs to editor again
+	watcher.Remove(ui)
+	writeTestFile(t, filepath.Join(ui, "src", "index.ts"), "changed again")
+	waitForEvent(t, editorEvents, filepath.Join(ui, "src", "index.ts"))
+	expectNoEvent(t, uiEvents)
+}
+
+func TestFileWatcherNewAndIgnoredFolders(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "src", "index.ts"), "")
+	pkg := helpers.NodePackage{
+		Path:        dir,
+		Strategy:    helpers.TRANSPILED,
+		PackageJson: &helpers.PackageJson{Name: "@mediatool/ui"},
+	}
+	ignore, err := helpers.NewIgnoreMatcher(pkg)
+	if err != nil {
+		t.Fatalf("Failed to read ignore rules: %v", err)
+	}
+
+	watcher := newTestFileWatcher(t)
+	events, err := watcher.Add(dir, ignore)
+	if err != nil {
+		t.Fatalf("Failed to watch package: %v", err)
+	}
+
+	// Files in a new folder are watched
+	if err := os.Mkdir(filepath.Join(dir, "src", "forms"), 0755); err != nil {
+		t.Fatalf("Failed to create folder: %v", err)
+	}
+	if event := waitForEvent(t, events, filepath.Join(dir, "src", "forms")); !event.IsDir || !event.Has(helpers.WatchCreate) {
+		t.Errorf("Expected a folder create event, got: %+v", event)
+	}
+	writeTestFile(t, filepath.Join(dir, "src", "forms", "Input.tsx"), "")
+	waitForEvent(t, events, filepath.Join(dir, "src", "forms", "Input.tsx"))
+	drainEvents(events)
+
+	// The build output is ignored, even when the folder is removed
+	writeTestFile(t, filepath.Join(dir, "dist", "index.js"), "")
+	if err := os.RemoveAll(filepath.Join(dir, "dist")); err != nil {
+		t.Fatalf("Failed to remove dist: %v", err)
+	}
+	expectNoEvent(t, events)
+
+	if err := os.RemoveAll(filepath.Join(dir, "src", "forms")); err != nil {
+		t.Fatalf("Failed to remove folder: %v", err)
+	}
+	if event := waitForEvent(t, events, filepath.Join(dir, "src", "forms")); !event.Has(helpers.WatchRemove) {
+		t.Errorf("Expected a remove event, got: %+v", event)
+	}
+}
@@ -0,0 +1,71 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/LajnaLegenden/transpiler4/helpers"
+)
+
+func TestFilterPackages(t *testing.T) {
+	packages := []helpers.NodePackage{
+		{PackageJson: &helpers.PackageJson{Name: "@mediatool/editor"}, Strategy: helpers.TRANSPILED, IsFrontend: true},
+		{PackageJson: &helpers.PackageJson{Name: "@mediatool/media-types"}, Strategy: helpers.TRANSPILED},
+		{PackageJson: &helpers.PackageJson{Name: "@mediatool/amend-ui"}, Strategy: helpers.AMEND_NATIVE, IsFrontend: true},
+		{PackageJson: &helpers.PackageJson{Name: "native-tools"}, Strategy: helpers.MAKEFILE_BUILD},
+	}
+
+	names := func(selected []helpers.NodePackage) []string {
+		result := []string{}
+		for _, pkg := range selected {
+			result = append(result, pkg.PackageJson.Name)
+		}
+		return result
+	}
+
+	tests := []struct {
+		name     string
+		selector helpers.PackageSelector
+		expected []string
+	}{
+		{"exact name", helpers.PackageSelector{Packages: []string{"@mediatool/editor"}}, []string{"@mediatool/editor"}},
+		{"name without scope", helpers.PackageSelector{Packages: []string{"media-types"}}, []string{"@mediatool/media-types"}},
+		{"glob", helpers.PackageSelector{Packages: []string{"@mediatool/*"}, Exclude: []string{"*-ui"}}, []string{"@mediatool/editor", "@mediatool/media-types"}},
+		{"all", helpers.PackageSelector{All: true}, []string{"@mediatool/editor", "@mediatool/media-types", "@mediatool/amend-ui", "native-tools"}},
+		{"strategy", helpers.PackageSelector{Strategies: []string{"transpiled"}}, []string{"@mediatool/editor", "@mediatool/media-types"}},
+		{"frontend", helpers.PackageSelector{Frontend: true, Exclude: []string{"editor"}}, []string{"@mediatool/amend-ui"}},
+		{"names", helpers.PackageSelector{Names: []string{"native-tools", "@mediatool/editor"}}, []string{"@mediatool/editor", "native-tools"}},
+		{"names and globs", helpers.PackageSelector{Packages: []string{"*-ui"}, Names: []string{"native-tools"}}, []string{"@mediatool/amend-ui", "native-tools"}},
+	}
+
+	for _, test := range tests {
+		selected, err := helpers.FilterPackages(packages, test.selector)
+		if err != nil {
+			t.Errorf("%s: expected no error, got: %v", test.name, err)
+			continue
+		}
+		got := names(selected)
+		if len(got) != len(test.expected) {
+			t.Errorf("%s: expected %v, got: %v", test.name, test.expected, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.expected[i] {
+				t.Errorf("%s: expected %v, got: %v", test.name, test.expected, got)
+				break
+			}
+		}
+	}
+
+	if _, err := helpers.FilterPackages(packages, helpers.PackageSelector{Packages: []string{"does-not-exist"}}); err == nil {
+		t.Errorf("Expected an error for a package pattern that matches nothing")
+	}
+	// Names are not matched without their scope or as globs
+	for _, name := range []string{"editor", "@mediatool/*"} {
+		if _, err := helpers.FilterPackages(packages, helpers.PackageSelector{Names: []string{name}}); err == nil {
+			t.Errorf("Expected an error for the name %q that no package has", name)
+		}
+	}
+	if _, err := helpers.FilterPackages(packages, helpers.PackageSelector{Strategies: []string{"UNKNOWN"}}); err == nil {
+		t.Errorf("Expected an error when no package is selected")
+	}
+}
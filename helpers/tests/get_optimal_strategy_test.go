@@ -20,7 +20,7 @@ func TestGetOptimalStrategy(t *testing.T) {
 		},
 	}
 
-	strategy := helpers.GetOptimalStrategy(folderItems, packageJson)
+	strategy := helpers.GetOptimalStrategy(folderItems, packageJson, "")
 	if strategy != helpers.TRANSPILED {
 		t.Errorf("Expected TRANSPILED strategy, got %s", strategy)
 	}
@@ -37,7 +37,7 @@ func TestGetOptimalStrategy(t *testing.T) {
 		},
 	}
 
-	strategy = helpers.GetOptimalStrategy(folderItems, packageJson)
+	strategy = helpers.GetOptimalStrategy(folderItems, packageJson, "")
 	if strategy != helpers.TRANSPILED_LEGACY {
 		t.Errorf("Expected TRANSPILED_LEGACY strategy, got %s", strategy)
 	}
@@ -53,7 +53,7 @@ func TestGetOptimalStrategy(t *testing.T) {
 		Scripts: map[string]string{},
 	}
 
-	strategy = helpers.GetOptimalStrategy(folderItems, packageJson)
+	strategy = helpers.GetOptimalStrategy(folderItems, packageJson, "")
 	if strategy != helpers.AMEND_NATIVE {
 		t.Errorf("Expected AMEND_NATIVE strategy, got %s", strategy)
 	}
@@ -68,7 +68,7 @@ func TestGetOptimalStrategy(t *testing.T) {
 		Scripts: map[string]string{},
 	}
 
-	strategy = helpers.GetOptimalStrategy(folderItems, packageJson)
+	strategy = helpers.GetOptimalStrategy(folderItems, packageJson, "")
 	if strategy != helpers.MAKEFILE_BUILD {
 		t.Errorf("Expected MAKEFILE_BUILD strategy, got %s", strategy)
 	}
@@ -83,9 +83,9 @@ func TestGetOptimalStrategy(t *testing.T) {
 		Scripts: map[string]string{},
 	}
 
-	strategy = helpers.GetOptimalStrategy(folderItems, packageJson)
-	if strategy != "" {
-		t.Errorf("Expected empty strategy for no match, got %s", strategy)
+	strategy = helpers.GetOptimalStrategy(folderItems, packageJson, "")
+	if strategy != helpers.UNKNOWN {
+		t.Errorf("Expected UNKNOWN strategy for no match, got %s", strategy)
 	}
 
 	// Test case 6: nil packageJson
@@ -94,7 +94,7 @@ func TestGetOptimalStrategy(t *testing.T) {
 		"Makefile":     true,
 	}
 
-	strategy = helpers.GetOptimalStrategy(folderItems, nil)
+	strategy = helpers.GetOptimalStrategy(folderItems, nil, "")
 	if strategy != helpers.MAKEFILE_BUILD {
 		t.Errorf("Expected MAKEFILE_BUILD strategy with nil packageJson, got %s", strategy)
 	}
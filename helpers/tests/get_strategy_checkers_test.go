@@ -6,8 +6,8 @@ import (
 	"github.com/LajnaLegenden/transpiler4/helpers"
 )
 
-// We can't directly test the private getStrategyCheckers function
-// So we'll test it indirectly through GetOptimalStrategy
+// The built-in strategy checkers live in the strategy registry
+// So we test them through GetOptimalStrategy
 func TestStrategyCheckers(t *testing.T) {
 	// Test TRANSPILED strategy checker
 	folderItems := map[string]bool{
@@ -19,7 +19,7 @@ func TestStrategyCheckers(t *testing.T) {
 		},
 	}
 
-	strategy := helpers.GetOptimalStrategy(folderItems, packageJson)
+	strategy := helpers.GetOptimalStrategy(folderItems, packageJson, "")
 	if strategy != helpers.TRANSPILED {
 		t.Errorf("TRANSPILED strategy checker failed, got: %s", strategy)
 	}
@@ -32,7 +32,7 @@ func TestStrategyCheckers(t *testing.T) {
 		},
 	}
 
-	strategy = helpers.GetOptimalStrategy(folderItems, packageJson)
+	strategy = helpers.GetOptimalStrategy(folderItems, packageJson, "")
 	if strategy != helpers.TRANSPILED_LEGACY {
 		t.Errorf("TRANSPILED_LEGACY strategy checker failed, got: %s", strategy)
 	}
@@ -44,7 +44,7 @@ func TestStrategyCheckers(t *testing.T) {
 	}
 	packageJson = &helpers.PackageJson{}
 
-	strategy = helpers.GetOptimalStrategy(folderItems, packageJson)
+	strategy = helpers.GetOptimalStrategy(folderItems, packageJson, "")
 	if strategy != helpers.AMEND_NATIVE {
 		t.Errorf("AMEND_NATIVE strategy checker failed, got: %s", strategy)
 	}
@@ -55,7 +55,7 @@ func TestStrategyCheckers(t *testing.T) {
 	}
 	packageJson = &helpers.PackageJson{}
 
-	strategy = helpers.GetOptimalStrategy(folderItems, packageJson)
+	strategy = helpers.GetOptimalStrategy(folderItems, packageJson, "")
 	if strategy != helpers.MAKEFILE_BUILD {
 		t.Errorf("MAKEFILE_BUILD strategy checker failed, got: %s", strategy)
 	}
@@ -66,7 +66,7 @@ func TestStrategyCheckers(t *testing.T) {
 	}
 	packageJson = nil
 
-	strategy = helpers.GetOptimalStrategy(folderItems, packageJson)
+	strategy = helpers.GetOptimalStrategy(folderItems, packageJson, "")
 	if strategy != helpers.MAKEFILE_BUILD {
 		t.Errorf("Strategy checker with nil packageJson failed, got: %s", strategy)
 	}
@@ -75,8 +75,8 @@ func TestStrategyCheckers(t *testing.T) {
 	folderItems = map[string]bool{}
 	packageJson = &helpers.PackageJson{}
 
-	strategy = helpers.GetOptimalStrategy(folderItems, packageJson)
-	if strategy != "" {
+	strategy = helpers.GetOptimalStrategy(folderItems, packageJson, "")
+	if strategy != helpers.UNKNOWN {
 		t.Errorf("No matching strategy checker shouldn't match, got: %s", strategy)
 	}
 }
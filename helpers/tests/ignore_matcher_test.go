@@ -0,0 +1,101 @@
+package tests
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/LajnaLegenden/transpiler4/helpers"
+)
+
+func newIgnoreTestPackage(t *testing.T, config *helpers.BuildConfig) helpers.NodePackage {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, ".gitignore"), "# Generated\n*.log\ncoverage/\n/storybook-static\n!keep.log\n")
+	writeTestFile(t, filepath.Join(dir, ".npmignore"), "src/**/*.stories.tsx\n")
+	return helpers.NodePackage{
+		Path:        dir,
+		Strategy:    helpers.TRANSPILED,
+		PackageJson: &helpers.PackageJson{Name: "@mediatool/ui"},
+		Config:      config,
+	}
+}
+
+func TestIgnoreMatcher(t *testing.T) {
+	pkg := newIgnoreTestPackage(t, &helpers.BuildConfig{
+		Outputs: []string{"lib"},
+		Watch:   helpers.WatchConfig{Exclude: []string{"fixtures/"}},
+	})
+	matcher, err := helpers.NewIgnoreMatcher(pkg)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	tests := []struct {
+		path    string
+		isDir   bool
+		ignored bool
+	}{
+		{"src/index.ts", false, false},
+		{"test/index.test.ts", false, false},
+		{"node_modules", true, true},
+		{"src/node_modules/react/index.js", false, true},
+		{".git", true, true},
+		{"lib", true, true},        // Build output
+		{"lib", false, true},       // Removed output folder
+		{"src/lib", true, false},   // Outputs are relative to the package folder
+		{"debug.log", false, true}, // .gitignore
+		{"src/deep/debug.log", false, true},
+		{"keep.log", false, false}, // Negated
+		{"coverage", true, true},
+		{"coverage", false, false}, // Folder only pattern
+		{"coverage/index.html", false, true},
+		{"storybook-static", true, true},
+		{"src/storybook-static", true, false}, // Anchored pattern
+		{"src/Button.stories.tsx", false, true},
+		{"src/forms/Input.stories.tsx", false, true}, // .npmignore with **
+		{"src/Button.tsx", false, false},
+		{"fixtures/data.json", false, true}, // Config exclude
+		{"src/.index.ts.swp", false, true},  // Editor swap file
+		{"src/index.ts~", false, true},
+		{filepath.Join(pkg.Path, "src", "index.ts"), false, false},
+		{filepath.Join(pkg.Path, "debug.log"), false, true},
+	}
+	for _, test := range tests {
+		if ignored := matcher.Ignored(test.path, test.isDir); ignored != test.ignored {
+			t.Errorf("Ignored(%q, %v) = %v, expected %v", test.path, test.isDir, ignored, test.ignored)
+		}
+	}
+}
+
+func TestIgnoreMatcherInclude(t *testing.T) {
+	pkg := newIgnoreTestPackage(t, &helpers.BuildConfig{
+		Watch: helpers.WatchConfig{
+			Include: []string{"src/**/*.stories.tsx", "node_modules/"},
+		},
+	})
+	matcher, err := helpers.NewIgnoreMatcher(pkg)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if matcher.Ignored("src/Button.stories.tsx", false) {
+		t.Errorf("Include should bring back files ignored by .npmignore")
+	}
+	if !matcher.Ignored("debug.log", false) {
+		t.Errorf("Files not matching an include should stay ignored")
+	}
+	if !matcher.Ignored("node_modules", true) {
+		t.Errorf("node_modules should be ignored even when included")
+	}
+}
+
+func TestIgnoreMatcherNativeOutputs(t *testing.T) {
+	// Packages without build commands copy their sources, so they must be watched
+	pkg := newIgnoreTestPackage(t, &helpers.BuildConfig{Commands: []string{}, Outputs: []string{"lib"}})
+	matcher, err := helpers.NewIgnoreMatcher(pkg)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if matcher.Ignored("lib/index.js", false) {
+		t.Errorf("Outputs of packages without build commands should be watched")
+	}
+}
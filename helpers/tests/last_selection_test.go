@@ -0,0 +1,42 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/LajnaLegenden/transpiler4/helpers"
+)
+
+func TestLastSelection(t *testing.T) {
+	root := t.TempDir()
+
+	last, err := helpers.LoadLastSelection(root)
+	if err != nil || last != nil {
+		t.Errorf("Expected no selection before saving one, got: %v, %v", last, err)
+	}
+
+	selected := []helpers.NodePackage{
+		{PackageJson: &helpers.PackageJson{Name: "@mediatool/editor"}},
+		{PackageJson: &helpers.PackageJson{Name: "@mediatool/ui"}},
+	}
+	if err := helpers.SaveLastSelection(root, selected); err != nil {
+		t.Fatalf("Failed to save the selection: %v", err)
+	}
+	last, err = helpers.LoadLastSelection(root)
+	if err != nil {
+		t.Fatalf("Failed to load the selection: %v", err)
+	}
+	if expected := []string{"@mediatool/editor", "@mediatool/ui"}; !reflect.DeepEqual(last, expected) {
+		t.Errorf("Expected %v, got: %v", expected, last)
+	}
+
+	// A broken file is treated as no selection
+	if err := os.WriteFile(filepath.Join(root, helpers.StateDirName, "selection.json"), []byte("{"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if last, err := helpers.LoadLastSelection(root); err != nil || last != nil {
+		t.Errorf("Expected no selection from a broken file, got: %v, %v", last, err)
+	}
+}
@@ -0,0 +1,176 @@
+package tests
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/LajnaLegenden/transpiler4/logsocket"
+)
+
+// connectLogs connects to the log stream of the server with query and returns
+// the history header and the replayed messages
+func connectLogs(t *testing.T, serverURL string, query url.Values) (*websocket.Conn, logsocket.HistoryMessage, []logsocket.LogMessage) {
+	t.Helper()
+	wsURL := "ws" + strings.TrimPrefix(serverURL, "http") + "/ws"
+	if len(query) > 0 {
+		wsURL += "?" + query.Encode()
+	}
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	var header logsocket.HistoryMessage
+	if err := conn.ReadJSON(&header); err != nil || header.Type != "history" {
+		t.Fatalf("Expected the history header first, got %+v: %v", header, err)
+	}
+	replayed := make([]logsocket.LogMessage, header.Replayed)
+	for i := range replayed {
+		if err := conn.ReadJSON(&replayed[i]); err != nil {
+			t.Fatalf("Failed to read replayed message %d of %d: %v", i+1, header.Replayed, err)
+		}
+	}
+	return conn, header, replayed
+}
+
+// messagesOf returns the texts of the messages of pkg
+func messagesOf(messages []logsocket.LogMessage, pkg string) []string {
+	texts := []string{}
+	for _, message := range messages {
+		if message.Package == pkg {
+			texts = append(texts, message.Message)
+		}
+	}
+	return texts
+}
+
+func TestLogHistoryLimit(t *testing.T) {
+	serverURL := startControlServer(t)
+
+	total := logsocket.HistoryPerPackage + 250
+	logsocket.SendPackageLog("history-other", "first", 0)
+	for i := 0; i < total; i++ {
+		logsocket.SendPackageLog("history-limit", fmt.Sprintf("message %d", i), 0)
+		if i == 100 {
+			logsocket.SendPackageLog("history-other", "second", 0)
+		}
+	}
+	logsocket.SendPackageLog("history-other", "last", 0)
+
+	conn, header, replayed := connectLogs(t, serverURL, nil)
+	conn.Close()
+
+	// Only the latest messages of the busy package are kept
+	kept := messagesOf(replayed, "history-limit")
+	if len(kept) != logsocket.HistoryPerPackage {
+		t.Fatalf("Expected %d kept messages, got %d", logsocket.HistoryPerPackage, len(kept))
+	}
+	for i, text := range kept {
+		if expected := fmt.Sprintf("message %d", i+250); text != expected {
+			t.Fatalf("Expected %q at position %d after the ring wrapped around, got %q", expected, i, text)
+		}
+	}
+	// Messages of other packages are kept even when they are older
+	if other := messagesOf(replayed, "history-other"); strings.Join(other, ",") != "first,second,last" {
+		t.Errorf("Expected every message of the quiet package, got %v", other)
+	}
+	for i := 1; i < len(replayed); i++ {
+		if replayed[i].Seq <= replayed[i-1].Seq {
+			t.Fatalf("Expected the replay sorted by sequence number, got %d after %d", replayed[i].Seq, replayed[i-1].Seq)
+		}
+	}
+	if last := replayed[len(replayed)-1]; last.Message != "last" || last.Seq != header.Latest {
+		t.Errorf("Expected the latest message last with seq %d, got %+v", header.Latest, last)
+	}
+}
+
+func TestLogHistorySince(t *testing.T) {
+	serverURL := startControlServer(t)
+	logsocket.SendPackageLog("history-since", "before", 0)
+
+	conn, first, all := connectLogs(t, serverURL, nil)
+	conn.Close()
+	for i := 1; i <= 3; i++ {
+		logsocket.SendPackageLog("history-since", fmt.Sprintf("missed %d", i), 0)
+	}
+
+	tests := []struct {
+		name     string
+		since    uint64
+		session  string
+		expected int // Replayed messages
+	}{
+		{"current session", first.Latest, first.Session, 3},
+		{"up to date", first.Latest + 3, first.Session, 0},
+		// Sequence numbers of an earlier server mean nothing to this one
+		{"stale session", first.Latest, "stale", len(all) + 3},
+		{"ahead of the server", first.Latest + 100, first.Session, len(all) + 3},
+	}
+	for _, test := range tests {
+		query := url.Values{"since": {strconv.FormatUint(test.since, 10)}, "session": {test.session}}
+		conn, header, replayed := connectLogs(t, serverURL, query)
+		conn.Close()
+		if header.Session != first.Session || header.Latest != first.Latest+3 {
+			t.Errorf("%s: expected session %s at seq %d, got %+v", test.name, first.Session, first.Latest+3, header)
+		}
+		if len(replayed) != test.expected {
+			t.Errorf("%s: expected %d replayed messages, got %d", test.name, test.expected, len(replayed))
+			continue
+		}
+		if test.expected == 3 && strings.Join(messagesOf(replayed, "history-since"), ",") != "missed 1,missed 2,missed 3" {
+			t.Errorf("%s: expected the missed messages, got %v", test.name, replayed)
+		}
+	}
+}
+
+func TestLogHistoryReconnect(t *testing.T) {
+	serverURL := startControlServer(t)
+	for i := 0; i < 5; i++ {
+		logsocket.SendPackageLog("history-reconnect", strconv.Itoa(i), 0)
+	}
+
+	// Messages broadcast while the client connects are either replayed or
+	// sent afterwards, never both and never neither
+	const live = 500
+	go func() {
+		for i := 5; i < 5+live; i++ {
+			logsocket.SendPackageLog("history-reconnect", strconv.Itoa(i), 0)
+		}
+	}()
+	conn, header, messages := connectLogs(t, serverURL, nil)
+	received := messagesOf(messages, "history-reconnect")
+	for len(received) < 5+live {
+		var message logsocket.LogMessage
+		if err := conn.ReadJSON(&message); err != nil {
+			t.Fatalf("Failed to read after %d messages: %v", len(received), err)
+		}
+		if message.Package == "history-reconnect" {
+			received = append(received, message.Message)
+		}
+		messages = append(messages, message)
+	}
+	conn.Close()
+	for i, text := range received {
+		if text != strconv.Itoa(i) {
+			t.Fatalf("Expected message %d at position %d, got %s", i, i, text)
+		}
+	}
+
+	// A client that reconnects gets only what it missed
+	lastSeq := messages[len(messages)-1].Seq
+	for i := 0; i < 7; i++ {
+		logsocket.SendPackageLog("history-reconnect", "missed "+strconv.Itoa(i), 0)
+	}
+	query := url.Values{"since": {strconv.FormatUint(lastSeq, 10)}, "session": {header.Session}}
+	conn, _, replayed := connectLogs(t, serverURL, query)
+	conn.Close()
+	if len(replayed) != 7 || replayed[0].Seq != lastSeq+1 || replayed[0].Message != "missed 0" {
+		t.Errorf("Expected the 7 missed messages after seq %d, got %+v", lastSeq, replayed)
+	}
+}
@@ -0,0 +1,62 @@
+package tests
+
+import (
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/LajnaLegenden/transpiler4/logsocket"
+)
+
+func TestStartServer(t *testing.T) {
+	// An address that can't be bound is returned instead of only logged
+	if _, err := logsocket.StartServer("192.0.2.1", 0); err == nil {
+		logsocket.StopServer()
+		t.Fatal("Expected an error listening on an address of no interface")
+	}
+
+	// Keep a port busy
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer busy.Close()
+	busyPort := busy.Addr().(*net.TCPAddr).Port
+
+	port, err := logsocket.StartServer("127.0.0.1", busyPort)
+	if err != nil {
+		t.Fatalf("Expected the server to fall back to a free port, got: %v", err)
+	}
+	defer logsocket.StopServer()
+	if port == busyPort || port == 0 {
+		t.Fatalf("Expected a free port instead of %d, got %d", busyPort, port)
+	}
+
+	response, err := http.Get(logsocket.ServerURL("127.0.0.1", port) + "/")
+	if err != nil {
+		t.Fatalf("Expected the server to answer on port %d, got: %v", port, err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("Expected status 200 from the log viewer, got %d", response.StatusCode)
+	}
+}
+
+func TestServerURL(t *testing.T) {
+	tests := []struct {
+		bind     string
+		port     int
+		expected string
+	}{
+		{"", 2999, "http://localhost:2999"},
+		{"0.0.0.0", 2999, "http://localhost:2999"},
+		{"::", 4000, "http://localhost:4000"},
+		{"127.0.0.1", 4000, "http://127.0.0.1:4000"},
+		{"::1", 4000, "http://[::1]:4000"},
+	}
+	for _, test := range tests {
+		if got := logsocket.ServerURL(test.bind, test.port); got != test.expected {
+			t.Errorf("ServerURL(%q, %d): expected %s, got %s", test.bind, test.port, test.expected, got)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package tests
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/LajnaLegenden/transpiler4/helpers"
+)
+
+func TestPollWatcher(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "src", "index.ts"), "")
+	pkg := helpers.NodePackage{
+		Path:        dir,
+		Strategy:    helpers.TRANSPILED,
+		PackageJson: &helpers.PackageJson{Name: "@mediatool/ui"},
+	}
+	ignore, err := helpers.NewIgnoreMatcher(pkg)
+	if err != nil {
+		t.Fatalf("Failed to read ignore rules: %v", err)
+	}
+
+	watcher := helpers.NewPollWatcher(20*time.Millisecond, log.New(io.Discard, "", 0))
+	defer watcher.Close()
+	events, err := watcher.Add(dir, ignore)
+	if err != nil {
+		t.Fatalf("Failed to watch package: %v", err)
+	}
+
+	writeTestFile(t, filepath.Join(dir, "src", "index.ts"), "changed")
+	if event := waitForEvent(t, events, filepath.Join(dir, "src", "index.ts")); !event.Has(helpers.WatchWrite) {
+		t.Errorf("Expected a write event, got: %+v", event)
+	}
+
+	// Only the new folder is reported, not every file in it
+	writeTestFile(t, filepath.Join(dir, "src", "forms", "Input.tsx"), "")
+	if event := waitForEvent(t, events, filepath.Join(dir, "src", "forms")); !event.IsDir || !event.Has(helpers.WatchCreate) {
+		t.Errorf("Expected a folder create event, got: %+v", event)
+	}
+	expectNoEvent(t, events)
+
+	// The build output is ignored
+	writeTestFile(t, filepath.Join(dir, "dist", "index.js"), "")
+	expectNoEvent(t, events)
+
+	if err := os.RemoveAll(filepath.Join(dir, "src", "forms")); err != nil {
+		t.Fatalf("Failed to remove folder: %v", err)
+	}
+	if event := waitForEvent(t, events, filepath.Join(dir, "src", "forms")); !event.Has(helpers.WatchRemove) {
+		t.Errorf("Expected a remove event, got: %+v", event)
+	}
+
+	watcher.Remove(dir)
+	writeTestFile(t, filepath.Join(dir, "src", "index.ts"), "changed again")
+	expectNoEvent(t, events)
+}
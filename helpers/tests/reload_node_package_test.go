@@ -0,0 +1,98 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/LajnaLegenden/transpiler4/helpers"
+)
+
+func TestReloadNodePackage(t *testing.T) {
+	rootDir := t.TempDir()
+	packageDir := filepath.Join(rootDir, "packages", "ui")
+	if err := os.MkdirAll(packageDir, 0755); err != nil {
+		t.Fatalf("Failed to create package directory: %v", err)
+	}
+	writeFile := func(name string, content string) {
+		if err := os.WriteFile(filepath.Join(packageDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	writeFile("package.json", `{"name": "ui", "scripts": {"build": "tsc"}}`)
+
+	packages, err := helpers.FindNodePackages(rootDir)
+	if err != nil || len(packages) != 1 {
+		t.Fatalf("Expected to find one package, got %d: %v", len(packages), err)
+	}
+	pkg := packages[0]
+	if pkg.Strategy != helpers.TRANSPILED_LEGACY {
+		t.Fatalf("Expected strategy %s, got %s", helpers.TRANSPILED_LEGACY, pkg.Strategy)
+	}
+
+	// Nothing changed
+	reloaded, err := helpers.ReloadNodePackage(pkg)
+	if err != nil {
+		t.Fatalf("Expected no error from ReloadNodePackage, got: %v", err)
+	}
+	if changes := helpers.DescribePackageChanges(pkg, reloaded); len(changes) != 0 {
+		t.Errorf("Expected no changes, got %v", changes)
+	}
+
+	// Adding a rollup config switches to the transpile strategy
+	writeFile("rollup.config.mjs", "export default {}")
+	writeFile("package.json", `{"name": "@scope/ui", "scripts": {"build": "tsc"}, "dependencies": {"core": "1.0.0"}}`)
+	reloaded, err = helpers.ReloadNodePackage(pkg)
+	if err != nil {
+		t.Fatalf("Expected no error from ReloadNodePackage, got: %v", err)
+	}
+	if reloaded.Strategy != helpers.TRANSPILED {
+		t.Errorf("Expected strategy %s after adding a rollup config, got %s", helpers.TRANSPILED, reloaded.Strategy)
+	}
+	if reloaded.RootPath != pkg.RootPath {
+		t.Errorf("Expected root path %s, got %s", pkg.RootPath, reloaded.RootPath)
+	}
+
+	changes := strings.Join(helpers.DescribePackageChanges(pkg, reloaded), "\n")
+	for _, expected := range []string{
+		"name changed from ui to @scope/ui",
+		"strategy changed from TRANSPILED_LEGACY to TRANSPILED",
+		"build commands changed from pnpm prepublishOnly to pnpm transpile",
+		"now depends on core",
+	} {
+		if !strings.Contains(changes, expected) {
+			t.Errorf("Expected the changes to contain %q, got:\n%s", expected, changes)
+		}
+	}
+
+	// A broken package.json keeps the package as it was
+	writeFile("package.json", `{"name": `)
+	if _, err := helpers.ReloadNodePackage(pkg); err == nil {
+		t.Error("Expected an error for a broken package.json")
+	}
+}
+
+func TestIsMetadataChange(t *testing.T) {
+	pkg := helpers.NodePackage{Path: "/repo/packages/ui"}
+
+	tests := []struct {
+		name     string
+		event    helpers.WatchEvent
+		expected bool
+	}{
+		{"package.json written", helpers.WatchEvent{Path: "/repo/packages/ui/package.json", Op: helpers.WatchWrite}, true},
+		{"config written", helpers.WatchEvent{Path: "/repo/packages/ui/.mtcli.yaml", Op: helpers.WatchWrite}, true},
+		{"ignore file written", helpers.WatchEvent{Path: "/repo/packages/ui/.gitignore", Op: helpers.WatchWrite}, true},
+		{"rollup config created", helpers.WatchEvent{Path: "/repo/packages/ui/rollup.config.js", Op: helpers.WatchCreate}, true},
+		{"Makefile removed", helpers.WatchEvent{Path: "/repo/packages/ui/Makefile", Op: helpers.WatchRemove}, true},
+		{"source written", helpers.WatchEvent{Path: "/repo/packages/ui/index.ts", Op: helpers.WatchWrite}, false},
+		{"nested package.json", helpers.WatchEvent{Path: "/repo/packages/ui/src/package.json", Op: helpers.WatchWrite}, false},
+		{"nested file created", helpers.WatchEvent{Path: "/repo/packages/ui/src/new.ts", Op: helpers.WatchCreate}, false},
+	}
+	for _, test := range tests {
+		if got := helpers.IsMetadataChange(pkg, test.event); got != test.expected {
+			t.Errorf("%s: expected %t, got %t", test.name, test.expected, got)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+//go:build !windows
+
+package tests
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/LajnaLegenden/transpiler4/helpers"
+)
+
+func TestRunCommandCancelKillsProcessTree(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "run-command-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// The shell starts a child that ignores SIGTERM, like a busy bundler might
+	pidFile := filepath.Join(tempDir, "child.pid")
+	command := "sh -c 'trap \"\" TERM; sleep 30' & echo $! > " + pidFile + "; wait"
+
+	previousGracePeriod := helpers.CommandGracePeriod
+	helpers.CommandGracePeriod = 200 * time.Millisecond
+	defer func() { helpers.CommandGracePeriod = previousGracePeriod }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		// Wait for the child to start before cancelling
+		for i := 0; i < 100; i++ {
+			if data, err := os.ReadFile(pidFile); err == nil && len(strings.TrimSpace(string(data))) > 0 {
+				break
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		cancel()
+	}()
+
+	start := time.Now()
+	err = helpers.RunCommand(ctx, command, tempDir)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Cancelled command should stop quickly, took %s", elapsed)
+	}
+
+	data, err := os.ReadFile(pidFile)
+	if err != nil {
+		t.Fatalf("Failed to read child pid: %v", err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		t.Fatalf("Invalid child pid %q: %v", data, err)
+	}
+	// Give the kernel a moment to reap the child
+	for i := 0; i < 50; i++ {
+		if syscall.Kill(pid, 0) != nil || isZombie(pid) {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Errorf("Child process %d is still running after cancel", pid)
+}
+
+// isZombie reports whether pid has exited but was not reaped yet, which
+// happens in containers where nothing reaps orphaned processes
+func isZombie(pid int) bool {
+	data, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return false
+	}
+	fields := strings.Fields(string(data[strings.LastIndex(string(data), ")")+1:]))
+	return len(fields) > 0 && fields[0] == "Z"
+}
+
+func TestKillRunningCommands(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "kill-running-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	pidFile := filepath.Join(tempDir, "child.pid")
+	command := "sh -c 'trap \"\" TERM; sleep 30' & echo $! > " + pidFile + "; wait"
+
+	done := make(chan error, 1)
+	go func() {
+		done <- helpers.RunCommand(context.Background(), command, tempDir)
+	}()
+	var pid int
+	for i := 0; i < 100 && pid == 0; i++ {
+		if data, err := os.ReadFile(pidFile); err == nil {
+			pid, _ = strconv.Atoi(strings.TrimSpace(string(data)))
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if pid == 0 {
+		t.Fatal("The command did not start its child")
+	}
+
+	helpers.KillRunningCommands()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("Expected an error from a killed command")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Killed command did not return")
+	}
+	for i := 0; i < 50; i++ {
+		if syscall.Kill(pid, 0) != nil || isZombie(pid) {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Errorf("Child process %d is still running after KillRunningCommands", pid)
+}
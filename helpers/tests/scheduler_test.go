@@ -0,0 +1,128 @@
+package tests
+
+import (
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/LajnaLegenden/transpiler4/helpers"
+)
+
+func TestSchedulerLimitsConcurrency(t *testing.T) {
+	scheduler := helpers.NewScheduler(2, log.New(io.Discard, "", 0))
+
+	var running, maxRunning int32
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		scheduler.Submit(name, func() {
+			current := atomic.AddInt32(&running, 1)
+			for {
+				max := atomic.LoadInt32(&maxRunning)
+				if current <= max || atomic.CompareAndSwapInt32(&maxRunning, max, current) {
+					break
+				}
+			}
+			time.Sleep(20 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+		})
+	}
+	scheduler.Wait()
+
+	if maxRunning != 2 {
+		t.Errorf("Expected at most 2 builds at once, got: %d", maxRunning)
+	}
+}
+
+func TestSchedulerDeduplicatesPendingJobs(t *testing.T) {
+	scheduler := helpers.NewScheduler(1, log.New(io.Discard, "", 0))
+
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var order []string
+	record := func(name string) func() {
+		return func() {
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+		}
+	}
+
+	// Occupy the only slot so everything else waits
+	scheduler.Submit("blocker", func() { <-release })
+	scheduler.Submit("editor", record("editor-1"))
+	scheduler.Submit("ui", record("ui"))
+	if _, queued := scheduler.Submit("editor", record("editor-2")); queued {
+		t.Errorf("Second build of a waiting package should not be queued")
+	}
+	if queued := scheduler.Queued(); len(queued) != 2 || queued[0] != "editor" || queued[1] != "ui" {
+		t.Errorf("Expected [editor ui] waiting, got: %v", queued)
+	}
+
+	close(release)
+	scheduler.Wait()
+
+	if len(order) != 2 || order[0] != "editor-1" || order[1] != "ui" {
+		t.Errorf("Expected jobs to run once in FIFO order, got: %v", order)
+	}
+}
+
+func TestSchedulerSerializesSamePackage(t *testing.T) {
+	scheduler := helpers.NewScheduler(4, log.New(io.Discard, "", 0))
+
+	release := make(chan struct{})
+	first, _ := scheduler.Submit("editor", func() { <-release })
+
+	// A new build for a package that is running waits for the running one
+	var secondStarted int32
+	second, queued := scheduler.Submit("editor", func() { atomic.StoreInt32(&secondStarted, 1) })
+	if !queued {
+		t.Fatalf("Build of a running package should be queued")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if atomic.LoadInt32(&secondStarted) != 0 {
+		t.Errorf("Second build started while the first was still running")
+	}
+
+	close(release)
+	<-first
+	<-second
+	if atomic.LoadInt32(&secondStarted) != 1 {
+		t.Errorf("Second build should run after the first finished")
+	}
+}
+
+func TestSchedulerDrop(t *testing.T) {
+	scheduler := helpers.NewScheduler(1, log.New(io.Discard, "", 0))
+
+	release := make(chan struct{})
+	var ran atomic.Bool
+	scheduler.Submit("blocker", func() { <-release })
+	done, _ := scheduler.Submit("editor", func() { ran.Store(true) })
+
+	if scheduler.Drop("blocker") {
+		t.Errorf("A running job should not be dropped")
+	}
+	if !scheduler.Drop("editor") {
+		t.Fatalf("Expected the waiting job to be dropped")
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the channel of the dropped job to be closed")
+	}
+	if queued := scheduler.Queued(); len(queued) != 0 {
+		t.Errorf("Expected nothing waiting, got: %v", queued)
+	}
+
+	// The package can be queued again
+	if _, queued := scheduler.Submit("editor", func() { ran.Store(true) }); !queued {
+		t.Errorf("Expected a new job for the dropped package to be queued")
+	}
+	close(release)
+	scheduler.Wait()
+	if !ran.Load() {
+		t.Errorf("Expected the job queued after the drop to run")
+	}
+}
@@ -0,0 +1,187 @@
+package tests
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/LajnaLegenden/transpiler4/helpers"
+)
+
+func TestSessionLock(t *testing.T) {
+	rootDir := t.TempDir()
+	lockPath := filepath.Join(rootDir, helpers.StateDirName, "session.lock")
+
+	lock, err := helpers.AcquireSessionLock(rootDir)
+	if err != nil {
+		t.Fatalf("Expected no error acquiring the lock, got: %v", err)
+	}
+	if lock.PID != os.Getpid() {
+		t.Errorf("Expected pid %d, got %d", os.Getpid(), lock.PID)
+	}
+
+	lock.Port = 2999
+	lock.Packages = []string{"@mediatool/ui"}
+	if err := lock.Save(); err != nil {
+		t.Fatalf("Expected no error saving the lock, got: %v", err)
+	}
+
+	// A second session sees the first one
+	_, err = helpers.AcquireSessionLock(rootDir)
+	var locked *helpers.SessionLockedError
+	if !errors.As(err, &locked) {
+		t.Fatalf("Expected a SessionLockedError, got: %v", err)
+	}
+	if locked.Lock.Port != 2999 || len(locked.Lock.Packages) != 1 || locked.Lock.Packages[0] != "@mediatool/ui" {
+		t.Errorf("Expected the saved port and packages, got port %d and %v", locked.Lock.Port, locked.Lock.Packages)
+	}
+
+	if err := lock.Release(); err != nil {
+		t.Fatalf("Expected no error releasing the lock, got: %v", err)
+	}
+	if _, err := os.Stat(lockPath); !os.IsNotExist(err) {
+		t.Errorf("Expected the lock file to be removed, got: %v", err)
+	}
+	if running, err := helpers.ReadSessionLock(rootDir); err != nil || running != nil {
+		t.Errorf("Expected no running session, got %v: %v", running, err)
+	}
+}
+
+func TestSessionLockStale(t *testing.T) {
+	rootDir := t.TempDir()
+	lockPath := filepath.Join(rootDir, helpers.StateDirName, "session.lock")
+	if err := os.MkdirAll(filepath.Dir(lockPath), 0755); err != nil {
+		t.Fatalf("Failed to create state directory: %v", err)
+	}
+
+	// The pid of a process that already exited
+	cmd := exec.Command("go", "version")
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("Failed to run a process: %v", err)
+	}
+	exitedPID := cmd.Process.Pid
+
+	for name, content := range map[string]string{
+		"exited session": `{"pid": ` + strconv.Itoa(exitedPID) + `, "port": 2999}`,
+		"broken file":    `{"pid": `,
+	} {
+		if err := os.WriteFile(lockPath, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write lock file: %v", err)
+		}
+		lock, err := helpers.AcquireSessionLock(rootDir)
+		if err != nil {
+			t.Errorf("%s: expected the lock to be replaced, got: %v", name, err)
+			continue
+		}
+		if lock.PID != os.Getpid() {
+			t.Errorf("%s: expected pid %d, got %d", name, os.Getpid(), lock.PID)
+		}
+		lock.Release()
+	}
+}
+
+func TestSessionLockConcurrent(t *testing.T) {
+	cmd := exec.Command("go", "version")
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("Failed to run a process: %v", err)
+	}
+	staleLock := `{"pid": ` + strconv.Itoa(cmd.Process.Pid) + `, "port": 2999}`
+
+	for round := 0; round < 20; round++ {
+		rootDir := t.TempDir()
+		lockPath := filepath.Join(rootDir, helpers.StateDirName, "session.lock")
+		os.MkdirAll(filepath.Dir(lockPath), 0755)
+		if err := os.WriteFile(lockPath, []byte(staleLock), 0644); err != nil {
+			t.Fatalf("Failed to write lock file: %v", err)
+		}
+
+		// Sessions starting at once all find the stale lock
+		var wg sync.WaitGroup
+		errs := make(chan error, 8)
+		for i := 0; i < cap(errs); i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := helpers.AcquireSessionLock(rootDir)
+				errs <- err
+			}()
+		}
+		wg.Wait()
+		close(errs)
+
+		acquired := 0
+		for err := range errs {
+			var locked *helpers.SessionLockedError
+			switch {
+			case err == nil:
+				acquired++
+			case errors.As(err, &locked):
+				// The lock was read in full
+				if locked.Lock.PID != os.Getpid() {
+					t.Errorf("Expected the lock of pid %d, got pid %d", os.Getpid(), locked.Lock.PID)
+				}
+			default:
+				t.Errorf("Expected the lock to be taken or held, got: %v", err)
+			}
+		}
+		if acquired != 1 {
+			t.Fatalf("Expected exactly one session to get the lock, got %d", acquired)
+		}
+	}
+}
+
+func TestJoinSessionLock(t *testing.T) {
+	rootDir := t.TempDir()
+	stateDir := filepath.Join(rootDir, helpers.StateDirName)
+	os.MkdirAll(stateDir, 0755)
+
+	// The running session, and a session that joined it and exited
+	running := exec.Command("sleep", "30")
+	if err := running.Start(); err != nil {
+		t.Fatalf("Failed to start a process: %v", err)
+	}
+	defer running.Process.Kill()
+	exited := exec.Command("go", "version")
+	if err := exited.Run(); err != nil {
+		t.Fatalf("Failed to run a process: %v", err)
+	}
+	runningPID, exitedPID := strconv.Itoa(running.Process.Pid), strconv.Itoa(exited.Process.Pid)
+	os.WriteFile(filepath.Join(stateDir, "session.lock"), []byte(`{"pid": `+runningPID+`, "packages": ["@mediatool/ui"]}`), 0644)
+	exitedPath := filepath.Join(stateDir, "session-"+exitedPID+".lock")
+	os.WriteFile(exitedPath, []byte(`{"pid": `+exitedPID+`, "packages": ["@mediatool/forms"]}`), 0644)
+
+	lock, err := helpers.JoinSessionLock(rootDir)
+	if err != nil {
+		t.Fatalf("Expected no error joining the session, got: %v", err)
+	}
+	lock.Packages = []string{"@mediatool/editor"}
+	if err := lock.Save(); err != nil {
+		t.Fatalf("Expected no error saving the lock, got: %v", err)
+	}
+
+	// This session's own packages are not watched by others
+	watched, err := helpers.WatchedByOtherSessions(rootDir)
+	if err != nil {
+		t.Fatalf("Expected no error reading the sessions, got: %v", err)
+	}
+	if len(watched) != 1 || watched["@mediatool/ui"] != running.Process.Pid {
+		t.Errorf("Expected only @mediatool/ui of pid %s, got: %v", runningPID, watched)
+	}
+	if _, err := os.Stat(exitedPath); !os.IsNotExist(err) {
+		t.Errorf("Expected the lock of the exited session to be removed, got: %v", err)
+	}
+	if running, err := helpers.ReadSessionLock(rootDir); err != nil || running == nil || running.Packages[0] != "@mediatool/ui" {
+		t.Errorf("Expected the running session to keep its lock, got %v: %v", running, err)
+	}
+
+	if err := lock.Release(); err != nil {
+		t.Fatalf("Expected no error releasing the lock, got: %v", err)
+	}
+	if paths, _ := filepath.Glob(filepath.Join(stateDir, "session-*.lock")); len(paths) != 0 {
+		t.Errorf("Expected the joined lock to be removed, got: %v", paths)
+	}
+}
@@ -0,0 +1,100 @@
+package tests
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/LajnaLegenden/transpiler4/helpers"
+)
+
+func TestRegisteredStrategiesOrder(t *testing.T) {
+	expected := []helpers.LinkingStrategy{
+		helpers.TRANSPILED_YARN,
+		helpers.TRANSPILED,
+		helpers.TRANSPILED_LEGACY,
+		helpers.AMEND_NATIVE,
+		helpers.MAKEFILE_BUILD,
+	}
+
+	var names []helpers.LinkingStrategy
+	for _, strategy := range helpers.RegisteredStrategies() {
+		names = append(names, strategy.Name)
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected built-in strategies in priority order %v, got: %v", expected, names)
+	}
+}
+
+func TestRegisterStrategy(t *testing.T) {
+	const custom helpers.LinkingStrategy = "CUSTOM_TEST"
+	defer helpers.UnregisterStrategy(custom)
+
+	err := helpers.RegisterStrategy(helpers.StrategyDefinition{
+		Name:     custom,
+		Priority: 1000,
+		Detect: func(folderItems map[string]bool, _ *helpers.PackageJson, _ string) bool {
+			return folderItems["custom.config.js"]
+		},
+		Generate: func(pkg helpers.NodePackage) helpers.BuildConfig {
+			return helpers.BuildConfig{Commands: []string{"custom build"}}
+		},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error registering strategy, got: %v", err)
+	}
+
+	// Registered with a higher priority than TRANSPILED, so it wins
+	folderItems := map[string]bool{"custom.config.js": true, "rollup.config.mjs": true}
+	strategy := helpers.GetOptimalStrategy(folderItems, &helpers.PackageJson{}, "")
+	if strategy != custom {
+		t.Errorf("Expected %s strategy, got: %s", custom, strategy)
+	}
+
+	config := helpers.GetBuildConfig(helpers.NodePackage{Strategy: custom})
+	if !reflect.DeepEqual(config.Commands, []string{"custom build"}) {
+		t.Errorf("Expected commands from the registered generator, got: %v", config.Commands)
+	}
+
+	if err := helpers.RegisterStrategy(helpers.StrategyDefinition{Name: "NO_DETECTOR"}); err == nil {
+		t.Errorf("Expected an error when registering a strategy without a detector")
+	}
+}
+
+func TestRegisterConfigStrategies(t *testing.T) {
+	const tsup helpers.LinkingStrategy = "TSUP"
+	defer helpers.UnregisterStrategy(tsup)
+
+	config := &helpers.RootConfig{
+		Strategies: []helpers.StrategyConfig{{
+			Name:     string(tsup),
+			Priority: 450,
+			Detect: helpers.StrategyDetect{
+				Files:        []string{"tsup.config.ts", "tsup.config.js"},
+				Dependencies: []string{"tsup"},
+			},
+			BuildConfig: helpers.BuildConfig{
+				Commands: []string{"pnpm tsup"},
+				Outputs:  []string{"dist"},
+			},
+		}},
+	}
+	if err := helpers.RegisterConfigStrategies(config); err != nil {
+		t.Fatalf("Expected no error registering config strategies, got: %v", err)
+	}
+
+	packageJson := &helpers.PackageJson{DevDependencies: map[string]string{"tsup": "^8.0.0"}}
+	folderItems := map[string]bool{"tsup.config.ts": true}
+	if strategy := helpers.GetOptimalStrategy(folderItems, packageJson, ""); strategy != tsup {
+		t.Errorf("Expected TSUP strategy, got: %s", strategy)
+	}
+
+	// Both rules have to match
+	if strategy := helpers.GetOptimalStrategy(folderItems, &helpers.PackageJson{}, ""); strategy == tsup {
+		t.Errorf("TSUP strategy should not match without the tsup dependency")
+	}
+
+	buildConfig := helpers.GetBuildConfig(helpers.NodePackage{Strategy: tsup})
+	if !reflect.DeepEqual(buildConfig.Commands, []string{"pnpm tsup"}) || !reflect.DeepEqual(buildConfig.Outputs, []string{"dist"}) {
+		t.Errorf("Expected build config from the declared strategy, got: %+v", buildConfig)
+	}
+}
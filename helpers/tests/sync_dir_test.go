@@ -0,0 +1,171 @@
+package tests
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/LajnaLegenden/transpiler4/helpers"
+)
+
+func writeTestFile(t *testing.T, path string, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("Failed to create directory for %s: %v", path, err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", path, err)
+	}
+}
+
+func TestSyncDir(t *testing.T) {
+	rootDir, err := os.MkdirTemp("", "sync dir test") // Spaces on purpose
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(rootDir)
+
+	source := filepath.Join(rootDir, "package", "dist")
+	target := filepath.Join(rootDir, "webapp", "node_modules", "@mediatool", "editor", "dist")
+	writeTestFile(t, filepath.Join(source, "index.js"), "export default 1")
+	writeTestFile(t, filepath.Join(source, "chunks", "a.js"), "a")
+	writeTestFile(t, filepath.Join(source, "chunks", "b.js"), "b")
+
+	// First sync creates the target
+	stats, err := helpers.SyncDir(context.Background(), source, target, helpers.SyncCompareMetadata)
+	if err != nil {
+		t.Fatalf("Expected no error from first sync, got: %v", err)
+	}
+	if stats.Added != 3 || stats.Changed != 0 || stats.Removed != 0 {
+		t.Errorf("Expected 3 added files, got: %s", stats)
+	}
+
+	// Nothing changed, so nothing is copied
+	stats, err = helpers.SyncDir(context.Background(), source, target, helpers.SyncCompareMetadata)
+	if err != nil {
+		t.Fatalf("Expected no error from second sync, got: %v", err)
+	}
+	if stats.HasChanges() || stats.Unchanged != 3 {
+		t.Errorf("Expected no changes, got: %s (%d unchanged)", stats, stats.Unchanged)
+	}
+
+	// Change one file, add one and remove one
+	writeTestFile(t, filepath.Join(source, "index.js"), "export default 2")
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(filepath.Join(source, "index.js"), later, later)
+	writeTestFile(t, filepath.Join(source, "chunks", "c.js"), "c")
+	os.Remove(filepath.Join(source, "chunks", "b.js"))
+
+	stats, err = helpers.SyncDir(context.Background(), source, target, helpers.SyncCompareMetadata)
+	if err != nil {
+		t.Fatalf("Expected no error from third sync, got: %v", err)
+	}
+	if stats.Added != 1 || stats.Changed != 1 || stats.Removed != 1 {
+		t.Errorf("Expected 1 added, 1 changed, 1 removed, got: %s", stats)
+	}
+
+	content, err := os.ReadFile(filepath.Join(target, "index.js"))
+	if err != nil || string(content) != "export default 2" {
+		t.Errorf("Expected updated index.js in target, got: %q (%v)", content, err)
+	}
+	if _, err := os.Stat(filepath.Join(target, "chunks", "b.js")); !os.IsNotExist(err) {
+		t.Errorf("Expected b.js to be removed from target")
+	}
+	if _, err := os.Stat(filepath.Join(target, "chunks", "c.js")); err != nil {
+		t.Errorf("Expected c.js to be added to target: %v", err)
+	}
+
+	// No staging or backup folders are left behind
+	siblings, _ := os.ReadDir(filepath.Dir(target))
+	if len(siblings) != 1 {
+		t.Errorf("Expected only the target folder next to target, got %d entries", len(siblings))
+	}
+}
+
+func TestSyncDirHashMode(t *testing.T) {
+	rootDir, err := os.MkdirTemp("", "sync-hash-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(rootDir)
+
+	source := filepath.Join(rootDir, "lib")
+	target := filepath.Join(rootDir, "target", "lib")
+	writeTestFile(t, filepath.Join(source, "index.js"), "same")
+	writeTestFile(t, filepath.Join(target, "index.js"), "same")
+
+	stats, err := helpers.SyncDir(context.Background(), source, target, helpers.SyncCompareHash)
+	if err != nil {
+		t.Fatalf("Expected no error from sync, got: %v", err)
+	}
+	if stats.HasChanges() {
+		t.Errorf("Files with the same content should be unchanged in hash mode, got: %s", stats)
+	}
+}
+
+func TestSyncDirMissingSource(t *testing.T) {
+	rootDir, err := os.MkdirTemp("", "sync-missing-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(rootDir)
+
+	_, err = helpers.SyncDir(context.Background(), filepath.Join(rootDir, "dist"), filepath.Join(rootDir, "target"), helpers.SyncCompareMetadata)
+	if err == nil {
+		t.Errorf("Expected an error when the source does not exist")
+	}
+}
+
+func TestSyncDirTargetNeverMissing(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the target is only swapped in one step on Linux")
+	}
+	rootDir := t.TempDir()
+	source := filepath.Join(rootDir, "package", "dist")
+	target := filepath.Join(rootDir, "webapp", "node_modules", "editor", "dist")
+	writeTestFile(t, filepath.Join(source, "index.js"), "0")
+	if _, err := helpers.SyncDir(context.Background(), source, target, helpers.SyncCompareHash); err != nil {
+		t.Fatalf("Expected no error from first sync, got: %v", err)
+	}
+
+	// Read the target like a dev server while it is replaced
+	stop := make(chan struct{})
+	missing := make(chan string, 1)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				close(missing)
+				return
+			default:
+			}
+			if _, err := os.Stat(filepath.Join(target, "index.js")); err != nil {
+				missing <- err.Error()
+				close(missing)
+				return
+			}
+		}
+	}()
+	for i := 1; i <= 50; i++ {
+		writeTestFile(t, filepath.Join(source, "index.js"), strconv.Itoa(i))
+		if _, err := helpers.SyncDir(context.Background(), source, target, helpers.SyncCompareHash); err != nil {
+			t.Fatalf("Expected no error from sync %d, got: %v", i, err)
+		}
+	}
+	close(stop)
+	if err, ok := <-missing; ok {
+		t.Errorf("Expected the target to exist while it was replaced: %s", err)
+	}
+
+	if data, _ := os.ReadFile(filepath.Join(target, "index.js")); string(data) != "50" {
+		t.Errorf("Expected the last sync to be in the target, got %q", data)
+	}
+	entries, _ := os.ReadDir(filepath.Dir(target))
+	if len(entries) != 1 {
+		t.Errorf("Expected no staging folders to be left behind, got %d entries", len(entries))
+	}
+}
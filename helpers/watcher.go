@@ -0,0 +1,312 @@
+package helpers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// ErrWatchLimit is returned when the operating system can't watch any more folders
+var ErrWatchLimit = errors.New("file watch limit reached")
+
+// WatchOp describes what happened to a watched path
+type WatchOp uint32
+
+const (
+	WatchCreate WatchOp = 1 << iota
+	WatchWrite
+	WatchRemove
+	WatchRename
+)
+
+// WatchEvent is a change below one of the folders added to a FileWatcher
+type WatchEvent struct {
+	Path  string
+	Op    WatchOp
+	IsDir bool // Only known for paths that still exist
+}
+
+// Has reports whether the event includes op
+func (e WatchEvent) Has(op WatchOp) bool {
+	return e.Op&op != 0
+}
+
+// FileWatcher watches the folders of many packages with a single fsnotify
+// watcher and routes every event to the package folder it belongs to
+type FileWatcher struct {
+	fsWatcher *fsnotify.Watcher
+	logger    *log.Logger
+	mu        sync.Mutex
+	roots     map[string]*watchRoot
+	done      chan struct{}
+}
+
+// watchRoot is a folder added with Add, along with everything watched below it
+type watchRoot struct {
+	path    string
+	ignore  *IgnoreMatcher
+	events  chan WatchEvent
+	removed chan struct{}
+	dirs    map[string]bool
+}
+
+// NewFileWatcher creates the watcher and starts routing events.
+// Errors that are not tied to a single package are logged to logger.
+func NewFileWatcher(logger *log.Logger) (*FileWatcher, error) {
+	fsWatcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, watchLimitError(err)
+	}
+	if logger == nil {
+		logger = log.Default()
+	}
+	w := &FileWatcher{
+		fsWatcher: fsWatcher,
+		logger:    logger,
+		roots:     make(map[string]*watchRoot),
+		done:      make(chan struct{}),
+	}
+	go w.run()
+	return w, nil
+}
+
+// Add starts watching the folder at path and every folder below it that ignore
+// does not skip. The returned channel receives the changes below path that are
+// not ignored. If the watch limit is reached, nothing below path is watched and
+// an error wrapping ErrWatchLimit explains how to raise the limit.
+func (w *FileWatcher) Add(path string, ignore *IgnoreMatcher) (<-chan WatchEvent, error) {
+	path = filepath.Clean(path)
+	root := &watchRoot{
+		path:    path,
+		ignore:  ignore,
+		events:  make(chan WatchEvent, 100),
+		removed: make(chan struct{}),
+		dirs:    make(map[string]bool),
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.roots[path]; ok {
+		return nil, fmt.Errorf("%s is already watched", path)
+	}
+	// Folders of a package inside the folder of another package move to the inner package
+	for _, other := range w.roots {
+		for dir := range other.dirs {
+			if isWithin(path, dir) {
+				delete(other.dirs, dir)
+			}
+		}
+	}
+	w.roots[path] = root
+	if err := w.addDirs(root, path); err != nil {
+		w.removeRoot(root)
+		return nil, err
+	}
+	return root.events, nil
+}
+
+// Remove stops watching the folder added with Add
+func (w *FileWatcher) Remove(path string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if root, ok := w.roots[filepath.Clean(path)]; ok {
+		w.removeRoot(root)
+	}
+}
+
+// WatchedDirs returns how many folders are watched in total
+func (w *FileWatcher) WatchedDirs() int {
+	return len(w.fsWatcher.WatchList())
+}
+
+// Close stops watching everything
+func (w *FileWatcher) Close() error {
+	close(w.done)
+	return w.fsWatcher.Close()
+}
+
+func (w *FileWatcher) run() {
+	for {
+		select {
+		case <-w.done:
+			return
+		case event, ok := <-w.fsWatcher.Events:
+			if !ok {
+				return
+			}
+			w.route(event)
+		case err, ok := <-w.fsWatcher.Errors:
+			if !ok {
+				return
+			}
+			if errors.Is(err, fsnotify.ErrEventOverflow) {
+				w.logger.Printf("Too many file changes at once, some were missed: %v", err)
+				continue
+			}
+			w.logger.Printf("Watcher error: %v", err)
+		}
+	}
+}
+
+// route updates the watched folders and passes the event on to the package it belongs to
+func (w *FileWatcher) route(event fsnotify.Event) {
+	// Chmod alone doesn't change the contents
+	if event.Op == fsnotify.Chmod {
+		return
+	}
+	path := filepath.Clean(event.Name)
+
+	w.mu.Lock()
+	root := w.rootFor(path)
+	if root == nil {
+		w.mu.Unlock()
+		return
+	}
+
+	watchEvent := WatchEvent{Path: path, Op: convertOp(event.Op)}
+	info, statErr := os.Stat(path)
+	if statErr == nil {
+		watchEvent.IsDir = info.IsDir()
+	} else {
+		watchEvent.IsDir = root.dirs[path]
+	}
+	// A removed path can't be checked for being a folder, so it is skipped if either would be ignored
+	if root.ignore != nil && (statErr == nil && root.ignore.Ignored(path, watchEvent.IsDir) ||
+		statErr != nil && (root.ignore.Ignored(path, false) || root.ignore.Ignored(path, true))) {
+		w.mu.Unlock()
+		return
+	}
+
+	switch {
+	case watchEvent.Has(WatchCreate) && watchEvent.IsDir:
+		if err := w.addDirs(root, path); err != nil {
+			w.logger.Printf("Failed to watch %s: %v", path, err)
+		}
+	case watchEvent.Has(WatchRemove) || watchEvent.Has(WatchRename):
+		w.removeDirs(root, path)
+	}
+	w.mu.Unlock()
+
+	select {
+	case root.events <- watchEvent:
+	case <-root.removed:
+	case <-w.done:
+	}
+}
+
+// rootFor returns the root with the longest path containing path. Must be called with w.mu held.
+func (w *FileWatcher) rootFor(path string) *watchRoot {
+	var found *watchRoot
+	for rootPath, root := range w.roots {
+		if isWithin(rootPath, path) && (found == nil || len(rootPath) > len(found.path)) {
+			found = root
+		}
+	}
+	return found
+}
+
+// addDirs watches dirPath and the folders below it for root. Folders belonging
+// to another root are left to that root. Must be called with w.mu held.
+func (w *FileWatcher) addDirs(root *watchRoot, dirPath string) error {
+	return filepath.Walk(dirPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// Folders can disappear again while we walk them, e.g. during a git checkout
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if path != root.path && (w.roots[path] != nil || root.ignore != nil && root.ignore.Ignored(path, true)) {
+			return filepath.SkipDir
+		}
+		if root.dirs[path] {
+			return nil
+		}
+		if err := w.fsWatcher.Add(path); err != nil {
+			if os.IsNotExist(err) {
+				return filepath.SkipDir
+			}
+			return fmt.Errorf("failed to watch %s: %w", path, watchLimitError(err))
+		}
+		root.dirs[path] = true
+		return nil
+	})
+}
+
+// removeDirs stops watching dirPath and the folders below it. Must be called with w.mu held.
+func (w *FileWatcher) removeDirs(root *watchRoot, dirPath string) {
+	for path := range root.dirs {
+		if isWithin(dirPath, path) {
+			// The watch is already gone if the folder was deleted
+			_ = w.fsWatcher.Remove(path)
+			delete(root.dirs, path)
+		}
+	}
+}
+
+// removeRoot stops watching everything of root. Must be called with w.mu held.
+func (w *FileWatcher) removeRoot(root *watchRoot) {
+	w.removeDirs(root, root.path)
+	delete(w.roots, root.path)
+	close(root.removed)
+	// Hand the folders back to the package around it, if any
+	if parent := w.rootFor(root.path); parent != nil {
+		if err := w.addDirs(parent, root.path); err != nil {
+			w.logger.Printf("Failed to watch %s: %v", root.path, err)
+		}
+	}
+}
+
+// isWithin reports whether path is parent or inside it
+func isWithin(parent string, path string) bool {
+	return path == parent || strings.HasPrefix(path, parent+string(filepath.Separator))
+}
+
+func convertOp(op fsnotify.Op) WatchOp {
+	var converted WatchOp
+	if op.Has(fsnotify.Create) {
+		converted |= WatchCreate
+	}
+	if op.Has(fsnotify.Write) {
+		converted |= WatchWrite
+	}
+	if op.Has(fsnotify.Remove) {
+		converted |= WatchRemove
+	}
+	if op.Has(fsnotify.Rename) {
+		converted |= WatchRename
+	}
+	return converted
+}
+
+// watchLimitError turns the errors returned when the watch limit is reached
+// into ErrWatchLimit, with a hint on how to raise the limit
+func watchLimitError(err error) error {
+	switch {
+	case errors.Is(err, syscall.ENOSPC):
+		hint := "raise fs.inotify.max_user_watches"
+		if data, readErr := os.ReadFile("/proc/sys/fs/inotify/max_user_watches"); readErr == nil {
+			hint = fmt.Sprintf("fs.inotify.max_user_watches is %s", strings.TrimSpace(string(data)))
+		}
+		return fmt.Errorf("%w (%s). Raise it with 'sudo sysctl fs.inotify.max_user_watches=524288' "+
+			"and add the setting to /etc/sysctl.conf to keep it after a reboot", ErrWatchLimit, hint)
+	case errors.Is(err, syscall.EMFILE):
+		if runtime.GOOS == "linux" {
+			return fmt.Errorf("%w (too many inotify instances). Raise it with 'sudo sysctl fs.inotify.max_user_instances=1024' "+
+				"and add the setting to /etc/sysctl.conf to keep it after a reboot", ErrWatchLimit)
+		}
+		return fmt.Errorf("%w (too many open files). Raise it with 'ulimit -n 10240' before starting mtcli", ErrWatchLimit)
+	}
+	return err
+}
@@ -0,0 +1,38 @@
+package logsocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// FollowLogs connects to the server of another mtcli instance at serverURL,
+// as returned by ServerURL, and calls handle for the log messages it kept, then for every message it
+// broadcasts. Returns once the connection is closed, with an error unless the
+// server closed it normally.
+func FollowLogs(serverURL string, handle func(LogMessage)) error {
+	url := "ws" + strings.TrimPrefix(serverURL, "http") + "/ws"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", url, err)
+	}
+	defer conn.Close()
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				return nil
+			}
+			return err
+		}
+		var message LogMessage
+		// Responses to commands have no package and are not meant for us
+		if err := json.Unmarshal(data, &message); err != nil || message.Package == "" {
+			continue
+		}
+		handle(message)
+	}
+}
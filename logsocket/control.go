@@ -0,0 +1,367 @@
+package logsocket
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/LajnaLegenden/transpiler4/helpers"
+)
+
+// ErrUnknownPackage is returned by a Controller for packages it doesn't watch
+var ErrUnknownPackage = errors.New("unknown package")
+
+var (
+	errNoController   = errors.New("no watch session is running")
+	errUnknownCommand = errors.New("unknown command")
+)
+
+// packageActions are the commands of POST /api/packages/<name>/<action>,
+// which affect exactly the named package
+var packageActions = []string{"rebuild", "pause", "resume", "cancel"}
+
+// PackageState describes a package of the running watch session
+type PackageState struct {
+	Name       string               `json:"name"`
+	Status     string               `json:"status"` // idle, queued, building or failed
+	Paused     bool                 `json:"paused"`
+	BuildingMs int64                `json:"buildingMs,omitempty"` // How long the running build has taken so far
+	LastResult *helpers.BuildResult `json:"lastResult,omitempty"`
+}
+
+// Controller lets clients of the server drive a running watch session
+type Controller interface {
+	// Packages returns the state of every watched package sorted by name
+	Packages() []PackageState
+	// Rebuild queues a build of the package
+	Rebuild(name string) error
+	// Pause stops changes from triggering builds of the package
+	Pause(name string) error
+	// Resume lets changes trigger builds of the package again
+	Resume(name string) error
+	// Cancel stops the running build of the package
+	Cancel(name string) error
+	// Add starts watching the packages matching a name or glob and returns their names
+	Add(pattern string) ([]string, error)
+	// Remove stops watching the packages matching a name or glob and returns their names
+	Remove(pattern string) ([]string, error)
+	// Available returns the packages that can be added
+	Available() []helpers.NodePackage
+	// Stop ends the session as if Ctrl-C was pressed
+	Stop()
+}
+
+// Command is a request sent by a client over the WebSocket connection
+type Command struct {
+	ID      json.RawMessage `json:"id,omitempty"` // Sent back unchanged with the response
+	Command string          `json:"command"`      // packages, rebuild, pause, resume, cancel, add or remove
+	Package string          `json:"package,omitempty"`
+}
+
+// CommandResponse answers a Command. Only the client that sent the command receives it.
+type CommandResponse struct {
+	Type     string          `json:"type"` // Always "response", to tell it apart from log messages
+	ID       json.RawMessage `json:"id,omitempty"`
+	OK       bool            `json:"ok"`
+	Error    string          `json:"error,omitempty"`
+	Packages []PackageState  `json:"packages,omitempty"`
+}
+
+var (
+	controller    Controller
+	controllerMux sync.RWMutex
+)
+
+// SetController makes the running watch session controllable through the
+// server. Passing nil disables the control API again.
+func SetController(c Controller) {
+	controllerMux.Lock()
+	defer controllerMux.Unlock()
+	controller = c
+}
+
+func currentController() Controller {
+	controllerMux.RLock()
+	defer controllerMux.RUnlock()
+	return controller
+}
+
+// runCommand runs a command against the controller. It returns the state of
+// the affected packages after the command ran, or before it for removed packages.
+func runCommand(command string, name string) ([]PackageState, error) {
+	c := currentController()
+	if c == nil {
+		return nil, errNoController
+	}
+
+	var err error
+	affected := []string{name}
+	states := c.Packages()
+	switch command {
+	case "packages":
+		return states, nil
+	case "rebuild":
+		err = c.Rebuild(name)
+	case "pause":
+		err = c.Pause(name)
+	case "resume":
+		err = c.Resume(name)
+	case "cancel":
+		err = c.Cancel(name)
+	case "add":
+		affected, err = c.Add(name)
+	case "remove":
+		affected, err = c.Remove(name)
+	default:
+		return nil, fmt.Errorf("%w %q", errUnknownCommand, command)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Removed packages are reported with the state they had before
+	if command != "remove" {
+		states = c.Packages()
+	}
+	result := []PackageState{}
+	for _, state := range states {
+		if slices.Contains(affected, state.Name) {
+			result = append(result, state)
+		}
+	}
+	return result, nil
+}
+
+// registerControlHandlers adds the REST endpoints of the control API to mux
+func registerControlHandlers(mux *http.ServeMux) {
+	// Every route is guarded, web pages must not drive the session
+	handle := func(pattern string, handler http.HandlerFunc) {
+		mux.HandleFunc(pattern, guardControl(handler))
+	}
+
+	handle("GET /api/packages", func(w http.ResponseWriter, r *http.Request) {
+		packages, err := runCommand("packages", "")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, packages)
+	})
+
+	// Adds packages to the session, the body is {"package": "<name or glob>"}
+	handle("POST /api/packages", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Package string `json:"package"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Package == "" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": `expected {"package": "<name or glob>"}`})
+			return
+		}
+		packages, err := runCommand("add", body.Package)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, packages)
+	})
+
+	handle("GET /api/available", func(w http.ResponseWriter, r *http.Request) {
+		c := currentController()
+		if c == nil {
+			writeError(w, errNoController)
+			return
+		}
+		writeJSON(w, http.StatusOK, c.Available())
+	})
+
+	// Lets another mtcli instance take over the mediatool root
+	handle("POST /api/stop", func(w http.ResponseWriter, r *http.Request) {
+		c := currentController()
+		if c == nil {
+			writeError(w, errNoController)
+			return
+		}
+		c.Stop()
+		writeJSON(w, http.StatusOK, map[string]bool{"ok": true})
+	})
+
+	// Package names contain a slash, e.g. /api/packages/@mediatool/ui
+	handle("GET /api/packages/{name...}", func(w http.ResponseWriter, r *http.Request) {
+		packages, err := runCommand("packages", "")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		for _, state := range packages {
+			if state.Name == r.PathValue("name") {
+				writeJSON(w, http.StatusOK, state)
+				return
+			}
+		}
+		writeError(w, fmt.Errorf("%w %s", ErrUnknownPackage, r.PathValue("name")))
+	})
+
+	// Removes packages from the session
+	handle("DELETE /api/packages/{name...}", func(w http.ResponseWriter, r *http.Request) {
+		packages, err := runCommand("remove", r.PathValue("name"))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, packages)
+	})
+
+	// e.g. POST /api/packages/@mediatool/ui/rebuild
+	handle("POST /api/packages/{path...}", func(w http.ResponseWriter, r *http.Request) {
+		path := r.PathValue("path")
+		slash := strings.LastIndex(path, "/")
+		if slash <= 0 {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "expected /api/packages/<name>/<action>"})
+			return
+		}
+		name, action := path[:slash], path[slash+1:]
+		// Listing, adding and removing have their own routes and may affect several packages
+		if !slices.Contains(packageActions, action) {
+			writeError(w, fmt.Errorf("%w %q, expected one of %s", errUnknownCommand, action, strings.Join(packageActions, ", ")))
+			return
+		}
+		packages, err := runCommand(action, name)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if len(packages) == 0 {
+			writeJSON(w, http.StatusOK, nil)
+			return
+		}
+		writeJSON(w, http.StatusOK, packages[0])
+	})
+}
+
+// guardControl rejects requests from other web pages: requests for another
+// host name, cross-origin requests, and changes without a JSON body, which a
+// plain form can't send
+func guardControl(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !allowedHost(r) {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": fmt.Sprintf("requests for host %s are not allowed", r.Host)})
+			return
+		}
+		if !sameOrigin(r) {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "cross-origin requests are not allowed"})
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			if err != nil || mediaType != "application/json" {
+				writeJSON(w, http.StatusUnsupportedMediaType, map[string]string{"error": "expected Content-Type: application/json"})
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+// allowedHost reports whether r was sent to localhost, a loopback address or
+// the address the server listens on. A page whose host name was pointed at
+// this machine (DNS rebinding) counts as same-origin, so the host name is
+// checked as well. A server listening on all interfaces accepts any IP
+// address, only host names can be rebound.
+func allowedHost(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = strings.Trim(r.Host, "[]")
+	}
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	if ip.IsLoopback() {
+		return true
+	}
+	serverMux.Lock()
+	bind := serverBind
+	serverMux.Unlock()
+	if bind == "" {
+		return true
+	}
+	bindIP := net.ParseIP(bind)
+	return bindIP != nil && (bindIP.IsUnspecified() || bindIP.Equal(ip))
+}
+
+// sameOrigin reports whether r has no Origin, like requests from scripts and
+// mtcli itself, or comes from a page served by this server
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// checkWebSocketOrigin lets only the log viewer served here and clients
+// without an Origin connect to /ws
+func checkWebSocketOrigin(r *http.Request) bool {
+	return allowedHost(r) && sameOrigin(r)
+}
+
+// handleCommand runs a command received from a WebSocket client and sends the response back to it
+func handleCommand(c *client, data []byte) {
+	var command Command
+	response := CommandResponse{Type: "response"}
+	if err := json.Unmarshal(data, &command); err != nil {
+		response.Error = fmt.Sprintf("invalid command: %v", err)
+	} else {
+		response.ID = command.ID
+		packages, err := runCommand(command.Command, command.Package)
+		if err != nil {
+			response.Error = err.Error()
+		} else {
+			response.OK = true
+			response.Packages = packages
+		}
+	}
+
+	jsonData, err := json.Marshal(response)
+	if err != nil {
+		log.Printf("Error marshaling command response: %v", err)
+		return
+	}
+	// Queued like the log messages, so writes to the connection never overlap
+	if !c.send(jsonData) {
+		log.Printf("Error sending command response: the client is closed or can't keep up")
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+// writeError responds with the status code matching err
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusConflict
+	switch {
+	case errors.Is(err, errNoController):
+		status = http.StatusServiceUnavailable
+	case errors.Is(err, ErrUnknownPackage), errors.Is(err, errUnknownCommand):
+		status = http.StatusNotFound
+	}
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,89 @@
+package logsocket
+
+import (
+	"sort"
+	"strconv"
+	"time"
+)
+
+// HistoryPerPackage is how many of the latest log messages of each package,
+// System included, are kept to replay to clients that connect later
+const HistoryPerPackage = 1000
+
+// HistoryMessage is the first message a client receives after connecting,
+// before the log messages it missed
+type HistoryMessage struct {
+	Type     string `json:"type"`     // Always "history"
+	Session  string `json:"session"`  // Changes when the server restarts, sequence numbers start over then
+	Latest   uint64 `json:"latest"`   // Sequence number of the latest log message
+	Replayed int    `json:"replayed"` // How many log messages follow
+}
+
+// logHistory numbers the broadcast log messages and keeps the latest ones.
+// Guarded by clientsMux, so a client never misses a message between the
+// replay and the broadcasts that follow it.
+type logHistory struct {
+	session  string
+	seq      uint64
+	packages map[string]*logRing
+}
+
+func newLogHistory() *logHistory {
+	return &logHistory{
+		session:  strconv.FormatInt(time.Now().UnixNano(), 36),
+		packages: make(map[string]*logRing),
+	}
+}
+
+// record numbers message and keeps it
+func (h *logHistory) record(message *LogMessage) {
+	h.seq++
+	message.Seq = h.seq
+	ring, ok := h.packages[message.Package]
+	if !ok {
+		ring = &logRing{messages: make([]LogMessage, 0, 16)}
+		h.packages[message.Package] = ring
+	}
+	ring.add(message)
+}
+
+// since returns the kept messages numbered after seq, oldest first. A client
+// of an earlier server gets everything.
+func (h *logHistory) since(session string, seq uint64) []LogMessage {
+	if session != h.session || seq > h.seq {
+		seq = 0
+	}
+	var messages []LogMessage
+	for _, ring := range h.packages {
+		messages = ring.appendSince(messages, seq)
+	}
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].Seq < messages[j].Seq
+	})
+	return messages
+}
+
+// logRing keeps the latest HistoryPerPackage messages of a package
+type logRing struct {
+	messages []LogMessage
+	next     int // Where the next message goes once the ring is full
+}
+
+func (r *logRing) add(message *LogMessage) {
+	if len(r.messages) < HistoryPerPackage {
+		r.messages = append(r.messages, *message)
+		return
+	}
+	r.messages[r.next] = *message
+	r.next = (r.next + 1) % HistoryPerPackage
+}
+
+// appendSince appends the messages numbered after seq to messages
+func (r *logRing) appendSince(messages []LogMessage, seq uint64) []LogMessage {
+	for _, message := range r.messages {
+		if message.Seq > seq {
+			messages = append(messages, message)
+		}
+	}
+	return messages
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"strconv"
 	"sync"
@@ -13,11 +14,19 @@ import (
 	"github.com/gorilla/websocket"
 )
 
+// DefaultPort is the port the server prefers when no other one is configured
+const DefaultPort = 2999
+
+// DefaultBind is the address the server listens on when no other one is
+// configured. The control API must not be reachable from other machines.
+const DefaultBind = "127.0.0.1"
+
 // LogMessage represents a structured log message
 type LogMessage struct {
 	Package string `json:"package"`
 	Message string `json:"message"`
 	Time    int64  `json:"time"`
+	Seq     uint64 `json:"seq"` // Numbers the messages of a server, starting at 1
 }
 
 var (
@@ -25,25 +34,30 @@ var (
 	upgrader = websocket.Upgrader{
 		ReadBufferSize:  1024,
 		WriteBufferSize: 1024,
-		CheckOrigin: func(r *http.Request) bool {
-			return true // Allow all origins
-		},
+		// Only the log viewer served here may connect from a browser
+		CheckOrigin: checkWebSocketOrigin,
 	}
 
 	// Clients holds all connected WebSocket clients
-	clients    = make(map[*websocket.Conn]bool)
+	clients    = make(map[*client]bool)
 	clientsMux sync.Mutex
 
+	// History holds the latest messages for clients that connect later
+	history = newLogHistory()
+
 	// Server variables
 	server     *http.Server
 	serverMux  sync.Mutex
 	isRunning  bool
 	serverPort int
+	serverBind string
 )
 
 // StartServer starts a web server that serves a Vue.js app with Tailwind CSS
-// and also hosts a WebSocket server for streaming build logs
-func StartServer() (int, error) {
+// and also hosts a WebSocket server for streaming build logs. It listens on
+// bind, all interfaces when empty, and on port unless that one is busy, then
+// on a free port. Returns the port it listens on.
+func StartServer(bind string, port int) (int, error) {
 	serverMux.Lock()
 	defer serverMux.Unlock()
 
@@ -51,8 +65,21 @@ func StartServer() (int, error) {
 		return serverPort, nil
 	}
 
-	// Generate our port number
-	serverPort = 2999
+	// Listen right away so a failure is returned instead of only logged
+	listener, err := net.Listen("tcp", net.JoinHostPort(bind, strconv.Itoa(port)))
+	if err != nil && port != 0 {
+		fallback, fallbackErr := net.Listen("tcp", net.JoinHostPort(bind, "0"))
+		if fallbackErr != nil {
+			return 0, fmt.Errorf("failed to listen on port %d: %w", port, err)
+		}
+		log.Printf("Can't use port %d (%v), using port %d instead", port, err, fallback.Addr().(*net.TCPAddr).Port)
+		listener, err = fallback, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to listen on port %d: %w", port, err)
+	}
+	serverPort = listener.Addr().(*net.TCPAddr).Port
+	serverBind = bind
 
 	// Create a new HTTP server mux
 	mux := http.NewServeMux()
@@ -69,24 +96,34 @@ func StartServer() (int, error) {
 	// Handle WebSocket connections
 	mux.HandleFunc("/ws", handleWebSocket)
 
+	// Control the running watch session
+	registerControlHandlers(mux)
+
 	// Create a new server
-	server = &http.Server{
-		Addr:    ":" + strconv.Itoa(serverPort),
-		Handler: mux,
-	}
+	server = &http.Server{Handler: mux}
 
-	// Start the server in a goroutine
-	go func() {
-		log.Printf("Starting web server on http://localhost:%d", serverPort)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+	// Serve in a goroutine
+	log.Printf("Starting web server on %s", ServerURL(serverBind, serverPort))
+	go func(server *http.Server) {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
 			log.Printf("Server error: %v", err)
 		}
-	}()
+	}(server)
 
 	isRunning = true
 	return serverPort, nil
 }
 
+// ServerURL returns the URL of a server listening on bind and port. A server
+// listening on all interfaces is reached through localhost.
+func ServerURL(bind string, port int) string {
+	host := bind
+	if ip := net.ParseIP(bind); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "localhost"
+	}
+	return "http://" + net.JoinHostPort(host, strconv.Itoa(port))
+}
+
 // StopServer stops the web server if it's running
 func StopServer() error {
 	serverMux.Lock()
@@ -105,55 +142,155 @@ func StopServer() error {
 	return nil
 }
 
-// handleWebSocket handles WebSocket connections
+// clientQueueSize is how many messages can wait for a slow client before it
+// is disconnected
+const clientQueueSize = 4096
+
+// client is a connected WebSocket client. Everything sent to it goes through
+// its queue, so writes never overlap and a slow client doesn't hold up the others.
+type client struct {
+	conn      *websocket.Conn
+	queue     chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newClient(conn *websocket.Conn) *client {
+	return &client{
+		conn:  conn,
+		queue: make(chan []byte, clientQueueSize),
+		done:  make(chan struct{}),
+	}
+}
+
+// send queues data for the client. Reports false if the client is closed or
+// its queue is full.
+func (c *client) send(data []byte) bool {
+	select {
+	case <-c.done:
+		return false
+	default:
+	}
+	select {
+	case c.queue <- data:
+		return true
+	default:
+		return false
+	}
+}
+
+// writeQueued writes the queued messages until the client is closed
+func (c *client) writeQueued() {
+	for {
+		select {
+		case <-c.done:
+			return
+		case data := <-c.queue:
+			if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				c.close()
+				return
+			}
+		}
+	}
+}
+
+// close disconnects the client
+func (c *client) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.conn.Close()
+	})
+}
+
+// handleWebSocket handles WebSocket connections. The client first receives
+// the kept log messages, or with ?since=<seq>&session=<session> only the ones
+// it missed since it was last connected.
 func handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	since, _ := strconv.ParseUint(r.URL.Query().Get("since"), 10, 64)
+	session := r.URL.Query().Get("session")
+
 	// Upgrade the HTTP connection to a WebSocket connection
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Println("Failed to upgrade to WebSocket:", err)
 		return
 	}
-	defer conn.Close()
 
-	// Register new client
+	// Take the history and register the client at once, so no message is
+	// missed or sent twice. Broadcasts wait in its queue until the history was sent.
+	c := newClient(conn)
 	clientsMux.Lock()
-	clients[conn] = true
+	messages := history.since(session, since)
+	header := HistoryMessage{
+		Type:     "history",
+		Session:  history.session,
+		Latest:   history.seq,
+		Replayed: len(messages),
+	}
+	clients[c] = true
 	clientsMux.Unlock()
 
 	// Remove client when connection closes
 	defer func() {
 		clientsMux.Lock()
-		delete(clients, conn)
+		delete(clients, c)
 		clientsMux.Unlock()
+		c.close()
 	}()
 
-	// Keep the connection alive
+	if err := replayHistory(conn, header, messages); err != nil {
+		log.Printf("Error replaying the log history: %v", err)
+		return
+	}
+	go c.writeQueued()
+
+	// Clients send commands to control the watch session
 	for {
-		_, _, err := conn.ReadMessage()
+		_, data, err := conn.ReadMessage()
 		if err != nil {
 			break
 		}
+		handleCommand(c, data)
+	}
+}
+
+// replayHistory sends the history header and the kept messages to a new
+// client, before anything is written from its queue
+func replayHistory(conn *websocket.Conn, header HistoryMessage, messages []LogMessage) error {
+	if err := conn.WriteJSON(header); err != nil {
+		return err
+	}
+	for _, message := range messages {
+		if err := conn.WriteJSON(message); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
-// broadcastMessage sends a message to all connected clients
+// broadcastMessage records a message in the history and queues it for all connected clients
 func broadcastMessage(message LogMessage) {
+	clientsMux.Lock()
+	history.record(&message)
 	jsonData, err := json.Marshal(message)
+	dropped := 0
+	if err == nil {
+		for c := range clients {
+			if !c.send(jsonData) {
+				dropped++
+				c.close()
+				delete(clients, c)
+			}
+		}
+	}
+	clientsMux.Unlock()
+
+	// Logging broadcasts too, so it has to wait until clientsMux is released
 	if err != nil {
 		log.Printf("Error marshaling log message: %v", err)
-		return
 	}
-
-	clientsMux.Lock()
-	defer clientsMux.Unlock()
-
-	for client := range clients {
-		err := client.WriteMessage(websocket.TextMessage, jsonData)
-		if err != nil {
-			log.Printf("Error sending message to client: %v", err)
-			client.Close()
-			delete(clients, client)
-		}
+	if dropped > 0 {
+		log.Printf("Disconnected %d clients that were closed or could not keep up with the log", dropped)
 	}
 }
 
@@ -275,6 +412,28 @@ func serveHTML(w http.ResponseWriter) {
                 </nav>
             </div>
             
+            <!-- Package Controls -->
+            <div v-if="activeState" class="flex items-center justify-between mb-4">
+                <div class="text-sm text-gray-600">
+                    <span class="font-semibold">{{ activeState.name }}</span>
+                    <span class="ml-2 px-2 py-0.5 rounded-full text-xs font-semibold" :class="statusClass(activeState)">
+                        {{ activeState.paused ? 'paused' : activeState.status }}
+                    </span>
+                    <span v-if="commandError" class="ml-2 text-red-600">{{ commandError }}</span>
+                </div>
+                <div class="space-x-2">
+                    <button @click="sendCommand('rebuild')" class="px-3 py-1 bg-blue-100 text-blue-700 rounded hover:bg-blue-200 transition">
+                        Rebuild
+                    </button>
+                    <button @click="sendCommand(activeState.paused ? 'resume' : 'pause')" class="px-3 py-1 bg-gray-200 text-gray-700 rounded hover:bg-gray-300 transition">
+                        {{ activeState.paused ? 'Resume' : 'Pause' }}
+                    </button>
+                    <button v-if="activeState.status === 'building'" @click="sendCommand('cancel')" class="px-3 py-1 bg-red-100 text-red-700 rounded hover:bg-red-200 transition">
+                        Cancel Build
+                    </button>
+                </div>
+            </div>
+
             <!-- Log Display -->
             <div class="log-container bg-gray-800 text-gray-100 rounded p-4 font-mono text-sm">
                 <transition-group name="fade">
@@ -309,12 +468,18 @@ func serveHTML(w http.ResponseWriter) {
                 const allLogs = ref([]);
                 const connectionStatus = ref('Connecting...');
                 const activeTab = ref('All');
+                const packageStates = ref({});
+                const commandError = ref('');
                 let nextId = 0;
                 let socket = null;
+                // The last log message received, so a reconnect only replays the ones missed
+                let lastSeq = 0;
+                let historySession = '';
+                let statePoller = null;
                 
                 // Compute unique package tabs
                 const tabs = computed(() => {
-                    const packages = ['All'];
+                    const packages = ['All', ...Object.keys(packageStates.value)];
                     allLogs.value.forEach(log => {
                         if (!packages.includes(log.package)) {
                             packages.push(log.package);
@@ -339,6 +504,43 @@ func serveHTML(w http.ResponseWriter) {
                     return allLogs.value.filter(log => log.package === packageName).length;
                 };
                 
+                // State of the package in the active tab, if the watch session can be controlled
+                const activeState = computed(() => packageStates.value[activeTab.value]);
+                
+                const statusClass = (state) => {
+                    if (state.paused) return 'bg-gray-200 text-gray-700';
+                    switch (state.status) {
+                        case 'building': return 'bg-blue-100 text-blue-700';
+                        case 'queued': return 'bg-yellow-100 text-yellow-700';
+                        case 'failed': return 'bg-red-100 text-red-700';
+                        default: return 'bg-green-100 text-green-700';
+                    }
+                };
+                
+                // Send a command for the package in the active tab to the watch session
+                const sendCommand = (command) => {
+                    if (socket && socket.readyState === WebSocket.OPEN) {
+                        commandError.value = '';
+                        socket.send(JSON.stringify({ command: command, package: activeTab.value }));
+                    }
+                };
+                
+                // Apply the response to a command
+                const handleResponse = (response) => {
+                    if (!response.ok) {
+                        // Polling fails while no watch session is running
+                        if (response.id !== 'packages') {
+                            commandError.value = response.error;
+                        }
+                        return;
+                    }
+                    const states = response.id === 'packages' ? {} : { ...packageStates.value };
+                    (response.packages || []).forEach(state => {
+                        states[state.name] = state;
+                    });
+                    packageStates.value = states;
+                };
+                
                 // Format timestamp
                 const formatTime = (timestamp) => {
                     const date = new Date(timestamp);
@@ -352,18 +554,45 @@ func serveHTML(w http.ResponseWriter) {
                 
                 const connectWebSocket = () => {
                     const protocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
-                    const wsUrl = protocol + '//' + window.location.host + '/ws';
+                    let wsUrl = protocol + '//' + window.location.host + '/ws';
+                    if (lastSeq > 0) {
+                        wsUrl += '?since=' + lastSeq + '&session=' + encodeURIComponent(historySession);
+                    }
                     
                     socket = new WebSocket(wsUrl);
                     
                     socket.onopen = () => {
                         connectionStatus.value = 'Connected';
                         console.log('WebSocket connection established');
+                        
+                        // Keep the package states up to date
+                        const requestStates = () => socket.send(JSON.stringify({ id: 'packages', command: 'packages' }));
+                        requestStates();
+                        clearInterval(statePoller);
+                        statePoller = setInterval(requestStates, 1000);
                     };
                     
                     socket.onmessage = (event) => {
                         try {
                             const logData = JSON.parse(event.data);
+                            if (logData.type === 'response') {
+                                handleResponse(logData);
+                                return;
+                            }
+                            if (logData.type === 'history') {
+                                // A restarted server numbers its messages from 1 again
+                                if (logData.session !== historySession) {
+                                    historySession = logData.session;
+                                    lastSeq = 0;
+                                }
+                                return;
+                            }
+                            if (logData.seq) {
+                                if (logData.seq <= lastSeq) {
+                                    return;
+                                }
+                                lastSeq = logData.seq;
+                            }
                             
                             // Add unique ID for Vue's key tracking
                             const logEntry = {
@@ -400,6 +629,8 @@ func serveHTML(w http.ResponseWriter) {
                     };
                     
                     socket.onclose = () => {
+                        clearInterval(statePoller);
+                        packageStates.value = {};
                         connectionStatus.value = 'Disconnected - Reconnecting...';
                         console.log('WebSocket connection closed, attempting to reconnect...');
                         setTimeout(connectWebSocket, 3000);
@@ -427,6 +658,10 @@ func serveHTML(w http.ResponseWriter) {
                     tabs,
                     activeTab,
                     connectionStatus,
+                    activeState,
+                    commandError,
+                    statusClass,
+                    sendCommand,
                     getLogCountForPackage,
                     formatTime,
                     clearLogs